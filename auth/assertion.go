@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// JWT bearer (RFC 7523) identifiers.
+const (
+	GrantTypeJWTBearer           = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+type AssertionOptions struct {
+	// Service account the assertion is issued by
+	Issuer string
+	// Subject of the assertion, defaults to the issuer
+	Subject string
+	// Token endpoint the assertion is for
+	Audience string
+	// kid of the key, lets the server pick the right one when several are registered
+	KeyID string
+	// Lifetime of the assertion, defaults to five minutes
+	TTL time.Duration
+}
+
+// NewJWTAssertion creates a short-lived assertion a service account presents
+// to a token endpoint instead of a shared secret (RFC 7523). key must be an
+// *rsa.PrivateKey or *ecdsa.PrivateKey whose public key is registered with the
+// server.
+func NewJWTAssertion(key crypto.Signer, opts AssertionOptions) (string, error) {
+	if opts.Issuer == "" || opts.Audience == "" {
+		return "", errors.New("issuer and audience are required")
+	}
+
+	if opts.Subject == "" {
+		opts.Subject = opts.Issuer
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+
+	method, err := signingMethodForKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iss": opts.Issuer,
+		"sub": opts.Subject,
+		"aud": opts.Audience,
+		"iat": now.Unix(),
+		"exp": now.Add(opts.TTL).Unix(),
+		"jti": jti,
+	})
+	if opts.KeyID != "" {
+		token.Header["kid"] = opts.KeyID
+	}
+	return token.SignedString(key)
+}
+
+// AssertionKeyStore looks up the public keys registered for service accounts.
+// AssertionKey returns nil and no error for unknown keys.
+type AssertionKeyStore interface {
+	AssertionKey(ctx context.Context, issuer, kid string) (crypto.PublicKey, error)
+}
+
+// MemoryAssertionKeyStore is an AssertionKeyStore of a fixed set of keys,
+// keyed by issuer and kid. Use an empty kid for keys without one.
+type MemoryAssertionKeyStore map[string]map[string]crypto.PublicKey
+
+func (s MemoryAssertionKeyStore) AssertionKey(ctx context.Context, issuer, kid string) (crypto.PublicKey, error) {
+	return s[issuer][kid], nil
+}
+
+type AssertionValidatorOptions struct {
+	// Registered service account keys
+	Keys AssertionKeyStore
+	// Accepted aud values, usually the URL of the token endpoint
+	Audience []string
+	// Longest lifetime an assertion may have, defaults to one hour
+	MaxLifetime time.Duration
+	// Where used jti values are remembered, defaults to NewMemoryNonceStore()
+	Nonces NonceStore
+}
+
+// AssertionValidator checks JWT bearer assertions (RFC 7523 section 3).
+type AssertionValidator struct {
+	Options AssertionValidatorOptions
+}
+
+func NewAssertionValidator(options AssertionValidatorOptions) *AssertionValidator {
+	if options.Keys == nil {
+		panic("keys must be set")
+	}
+
+	if len(options.Audience) == 0 {
+		panic("audience must be set")
+	}
+
+	if options.MaxLifetime <= 0 {
+		options.MaxLifetime = time.Hour
+	}
+
+	if options.Nonces == nil {
+		options.Nonces = NewMemoryNonceStore()
+	}
+
+	return &AssertionValidator{options}
+}
+
+// Validate verifies an assertion and uses up its jti, returning its claims.
+func (v *AssertionValidator) Validate(ctx context.Context, assertion string) (jwt.MapClaims, error) {
+	parsed, err := jwt.Parse(assertion, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, errors.Errorf("unsupported signing method %v", token.Header["alg"])
+		}
+
+		claims, _ := token.Claims.(jwt.MapClaims)
+		issuer, _ := claims["iss"].(string)
+		kid, _ := token.Header["kid"].(string)
+		if issuer == "" {
+			return nil, errors.New("missing iss claim")
+		}
+
+		key, err := v.Options.Keys.AssertionKey(ctx, issuer, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == nil {
+			return nil, errors.Errorf("no key %q is registered for %s", kid, issuer)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid assertion")
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("invalid assertion: missing sub claim")
+	}
+
+	if !containsAny(tokenAudience(claims), v.Options.Audience) {
+		return nil, errors.New("invalid assertion: unexpected audience")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("invalid assertion: missing exp claim")
+	}
+
+	expiresAt := time.Unix(int64(exp), 0)
+	now := time.Now()
+	if expiresAt.Sub(now) > v.Options.MaxLifetime {
+		return nil, errors.New("invalid assertion: lifetime is too long")
+	}
+
+	if iat, ok := claims["iat"].(float64); ok && expiresAt.Sub(time.Unix(int64(iat), 0)) > v.Options.MaxLifetime {
+		return nil, errors.New("invalid assertion: lifetime is too long")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, errors.New("invalid assertion: missing jti claim")
+	}
+
+	fresh, err := v.Options.Nonces.Consume("assertion:"+claims["iss"].(string)+":"+jti, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fresh {
+		return nil, errors.New("invalid assertion: it has already been used")
+	}
+	return claims, nil
+}
+
+// containsAny reports whether values and accepted have an element in common.
+func containsAny(values []string, accepted []string) bool {
+	for _, a := range accepted {
+		if containsAll(values, []string{a}) {
+			return true
+		}
+	}
+	return false
+}
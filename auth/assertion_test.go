@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestAssertionValidator(t *testing.T) {
+	rsaKey, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	const audience = "https://auth.example.com/token"
+	v := NewAssertionValidator(AssertionValidatorOptions{
+		Keys: MemoryAssertionKeyStore{
+			"reporting": {"key-1": &rsaKey.PublicKey, "key-2": &ecKey.PublicKey},
+		},
+		Audience:    []string{audience},
+		MaxLifetime: 10 * time.Minute,
+	})
+
+	newAssertion := func(t *testing.T, key stdcrypto.Signer, opts AssertionOptions) string {
+		assertion, err := NewJWTAssertion(key, opts)
+		require.NoError(t, err)
+		return assertion
+	}
+
+	t.Run("RSA", func(t *testing.T) {
+		assertion := newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})
+		claims, err := v.Validate(context.Background(), assertion)
+		require.NoError(t, err)
+		assert.Equal(t, "reporting", claims["iss"])
+		assert.Equal(t, "reporting", claims["sub"])
+
+		t.Run("Reused", func(t *testing.T) {
+			_, err := v.Validate(context.Background(), assertion)
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("ECDSA", func(t *testing.T) {
+		assertion := newAssertion(t, ecKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-2"})
+		_, err := v.Validate(context.Background(), assertion)
+		assert.NoError(t, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		assertion := newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-2"})
+		_, err := v.Validate(context.Background(), assertion)
+		assert.Error(t, err)
+	})
+
+	t.Run("UnknownIssuer", func(t *testing.T) {
+		assertion := newAssertion(t, rsaKey, AssertionOptions{Issuer: "billing", Audience: audience, KeyID: "key-1"})
+		_, err := v.Validate(context.Background(), assertion)
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		assertion := newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: "https://other.example.com/token", KeyID: "key-1"})
+		_, err := v.Validate(context.Background(), assertion)
+		assert.Error(t, err)
+	})
+
+	t.Run("LifetimeTooLong", func(t *testing.T) {
+		assertion := newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1", TTL: time.Hour})
+		_, err := v.Validate(context.Background(), assertion)
+		assert.Error(t, err)
+	})
+
+	t.Run("HMAC", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss": "reporting", "sub": "reporting", "aud": audience, "jti": "1",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "key-1"
+		assertion, err := token.SignedString([]byte("secret"))
+		require.NoError(t, err)
+
+		_, err = v.Validate(context.Background(), assertion)
+		assert.Error(t, err)
+	})
+
+	t.Run("TokenEndpoint", func(t *testing.T) {
+		key, err := crypto.GenerateRsaKey()
+		require.NoError(t, err)
+
+		c := NewClientCredentials(ClientCredentialsOptions{
+			Key:        key,
+			Clients:    MemoryClientStore{"reporting": {ID: "reporting", Scopes: []string{"reports:read"}}},
+			Assertions: v,
+		})
+
+		request := func(assertion string) *httptest.ResponseRecorder {
+			form := url.Values{"grant_type": {GrantTypeJWTBearer}, "assertion": {assertion}}
+			req := httptest.NewRequest("POST", audience, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			c.ServeHTTP(w, req)
+			return w
+		}
+
+		w := request(newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"}))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "reports:read", resp.Scope)
+
+		w = request(newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Subject: "alice", Audience: audience, KeyID: "key-1"}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_grant")
+
+		t.Run("ClientAssertion", func(t *testing.T) {
+			request := func(form url.Values) *httptest.ResponseRecorder {
+				form.Set("grant_type", GrantTypeClientCredentials)
+				req := httptest.NewRequest("POST", audience, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				c.ServeHTTP(w, req)
+				return w
+			}
+
+			w := request(url.Values{
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})},
+				"client_id":             {"reporting"},
+			})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			var resp TokenResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "reports:read", resp.Scope)
+
+			// Another client's id
+			w = request(url.Values{
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})},
+				"client_id":             {"billing"},
+			})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), "invalid_client")
+
+			// Signed with a key that isn't registered for the client
+			w = request(url.Values{
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {newAssertion(t, ecKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})},
+			})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), "invalid_client")
+
+			w = request(url.Values{
+				"client_assertion_type": {"urn:example:saml"},
+				"client_assertion":      {newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})},
+			})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+			w = request(url.Values{
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {newAssertion(t, rsaKey, AssertionOptions{Issuer: "reporting", Audience: audience, KeyID: "key-1"})},
+				"client_secret":         {"s3cret"},
+			})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "invalid_request")
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func decodeAuditRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRedactToken(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		assert.Equal(t, "", RedactToken(""))
+	})
+
+	t.Run("Stable", func(t *testing.T) {
+		assert.Equal(t, RedactToken("abc"), RedactToken("abc"))
+		assert.NotEqual(t, RedactToken("abc"), RedactToken("abd"))
+		assert.NotContains(t, RedactToken("abc"), "abc")
+	})
+}
+
+func TestRemoteIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "10.0.0.1", RemoteIP(req))
+
+	req.RemoteAddr = "10.0.0.1"
+	assert.Equal(t, "10.0.0.1", RemoteIP(req))
+}
+
+func TestAuditLogging(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+		Logger: logger,
+	})
+
+	t.Run("Issued", func(t *testing.T) {
+		buf.Reset()
+		token, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1", "jti": "abc"}, key, IssuerOptions{Logger: logger})
+		require.NoError(t, err)
+
+		records := decodeAuditRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, AuditTokenIssued, records[0]["msg"])
+		assert.Equal(t, "user-1", records[0]["sub"])
+		assert.Equal(t, "abc", records[0]["jti"])
+		assert.Equal(t, RedactToken(token), records[0]["token"])
+		assert.NotContains(t, buf.String(), token)
+	})
+
+	t.Run("Authenticated", func(t *testing.T) {
+		token, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1", "iss": "eli"}, key)
+		require.NoError(t, err)
+
+		buf.Reset()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		require.NoError(t, m.CheckJWT(w, req))
+
+		records := decodeAuditRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, AuditAuthenticated, records[0]["msg"])
+		assert.Equal(t, "INFO", records[0]["level"])
+		assert.Equal(t, "user-1", records[0]["sub"])
+		assert.Equal(t, "eli", records[0]["iss"])
+		assert.Equal(t, "10.0.0.1", records[0]["remote_ip"])
+		assert.NotContains(t, buf.String(), token)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", "bearer not-a-token")
+		require.Error(t, m.CheckJWT(w, req))
+
+		records := decodeAuditRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, AuditAuthFailed, records[0]["msg"])
+		assert.Equal(t, "WARN", records[0]["level"])
+		assert.NotEmpty(t, records[0]["reason"])
+		assert.Equal(t, RedactToken("not-a-token"), records[0]["token"])
+	})
+
+	t.Run("Unredacted", func(t *testing.T) {
+		m.Options.LogUnredactedTokens = true
+		defer func() { m.Options.LogUnredactedTokens = false }()
+
+		buf.Reset()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", "bearer not-a-token")
+		require.Error(t, m.CheckJWT(w, req))
+
+		records := decodeAuditRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "not-a-token", records[0]["token"])
+	})
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// GrantTypeClientCredentials is the grant_type of RFC 6749 section 4.4.
+const GrantTypeClientCredentials = "client_credentials"
+
+// Client is an OAuth client registered with the token endpoint.
+type Client struct {
+	ID string
+	// bcrypt hash of the client secret, see crypto.GeneratePasswordHash
+	SecretHash []byte
+	// Scopes the client may request, all of them are granted when it asks for none
+	Scopes []string
+	// Audiences the client may request tokens for, all of them are used when it asks for none
+	Audiences []string
+	// Where the OpenID provider may send authorization codes, compared exactly
+	RedirectURIs []string
+	// Public clients (single page and native apps) have no secret and must use PKCE
+	Public bool
+}
+
+// ClientStore looks up registered clients. Client returns nil and no error
+// for unknown ids.
+type ClientStore interface {
+	Client(ctx context.Context, id string) (*Client, error)
+}
+
+// MemoryClientStore is a ClientStore of a fixed set of clients, keyed by id.
+type MemoryClientStore map[string]*Client
+
+func (s MemoryClientStore) Client(ctx context.Context, id string) (*Client, error) {
+	return s[id], nil
+}
+
+// dummySecretHash is compared against when a client is unknown, so that unknown
+// and known ids take the same time to reject.
+var (
+	dummySecretHash     []byte
+	dummySecretHashOnce sync.Once
+)
+
+// authenticateClient checks the client_secret_basic or client_secret_post
+// credentials of a token request, r.ParseForm must have been called.
+func authenticateClient(ctx context.Context, clients ClientStore, r *http.Request) (*Client, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes both before base64 encoding them
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, &OAuthError{Code: "invalid_client", Status: http.StatusUnauthorized}
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, &OAuthError{Code: "invalid_client", Status: http.StatusUnauthorized}
+		}
+
+		if r.PostForm.Get("client_secret") != "" {
+			return nil, &OAuthError{Code: "invalid_request", Description: "only one client authentication method may be used"}
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if id == "" || secret == "" {
+		return nil, &OAuthError{Code: "invalid_client", Description: "client authentication is required", Status: http.StatusUnauthorized}
+	}
+
+	client, err := clients.Client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dummySecretHashOnce.Do(func() {
+		dummySecretHash, _ = crypto.GeneratePasswordHash([]byte("unknown client"))
+	})
+
+	hash := dummySecretHash
+	if client != nil {
+		hash = client.SecretHash
+	}
+
+	if !crypto.ComparePasswordHash(hash, []byte(secret)) || client == nil {
+		return nil, &OAuthError{Code: "invalid_client", Description: "client authentication failed", Status: http.StatusUnauthorized}
+	}
+	return client, nil
+}
+
+type ClientCredentialsOptions struct {
+	// Key used to sign access tokens
+	Key *rsa.PrivateKey
+	// Registered clients
+	Clients ClientStore
+	// Value of the iss claim of access tokens
+	Issuer string
+	// Lifetime of access tokens, defaults to one hour
+	TTL time.Duration
+	// Options used for issuing access tokens
+	IssuerOptions IssuerOptions
+	// When set, the JWT bearer grant (RFC 7523) is accepted too: service accounts
+	// authenticate with an assertion signed by their own key, its iss and sub
+	// must be the id of a registered client. Such an assertion can also be sent
+	// as client_assertion to authenticate a client_credentials request
+	Assertions *AssertionValidator
+}
+
+// ClientCredentials is a token endpoint for the client_credentials grant
+// (RFC 6749 section 4.4), issuing machine tokens for internal services.
+type ClientCredentials struct {
+	Options ClientCredentialsOptions
+}
+
+func NewClientCredentials(options ClientCredentialsOptions) *ClientCredentials {
+	if options.Key == nil {
+		panic("key must be set")
+	}
+
+	if options.Clients == nil {
+		panic("clients must be set")
+	}
+
+	if options.TTL <= 0 {
+		options.TTL = time.Hour
+	}
+
+	return &ClientCredentials{options}
+}
+
+// Token issues an access token for an authenticated client.
+func (c *ClientCredentials) Token(client *Client, scopes, audience []string) (*TokenResponse, error) {
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	} else if !HasScopes(client.Scopes, scopes...) {
+		return nil, &OAuthError{Code: "invalid_scope", Description: "the client may not request these scopes"}
+	}
+
+	if len(audience) == 0 {
+		audience = client.Audiences
+	} else if !containsAll(client.Audiences, audience) {
+		return nil, &OAuthError{Code: "invalid_target", Description: "the client may not request tokens for this audience"}
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":       client.ID,
+		"client_id": client.ID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(c.Options.TTL).Unix(),
+		"jti":       jti,
+	}
+	if len(audience) == 1 {
+		claims["aud"] = audience[0]
+	} else if len(audience) > 1 {
+		claims["aud"] = audience
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	if c.Options.Issuer != "" {
+		claims["iss"] = c.Options.Issuer
+	}
+
+	token, err := NewJWTWithClaims(claims, c.Options.Key, c.Options.IssuerOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(c.Options.TTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+func (c *ClientCredentials) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "token requests must use POST", Status: http.StatusMethodNotAllowed})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "malformed request body"})
+		return
+	}
+
+	var client *Client
+	var err error
+	grantType := r.PostForm.Get("grant_type")
+	switch {
+	case grantType == GrantTypeJWTBearer && c.Options.Assertions != nil:
+		client, err = c.authenticateAssertion(r)
+	case r.PostForm.Get("client_assertion_type") != "" && c.Options.Assertions != nil:
+		client, err = c.authenticateClientAssertion(r)
+	default:
+		client, err = authenticateClient(r.Context(), c.Options.Clients, r)
+	}
+	if err != nil {
+		audit(r.Context(), c.Options.IssuerOptions.Logger, slog.LevelWarn, AuditAuthFailed,
+			slog.String("remote_ip", RemoteIP(r)),
+			slog.String("reason", err.Error()),
+		)
+
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuthError(w, err)
+		return
+	}
+
+	if grantType != GrantTypeClientCredentials && (grantType != GrantTypeJWTBearer || c.Options.Assertions == nil) {
+		writeOAuthError(w, &OAuthError{Code: "unsupported_grant_type"})
+		return
+	}
+
+	resp, err := c.Token(client, strings.Fields(r.PostForm.Get("scope")), r.PostForm["audience"])
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// authenticateAssertion identifies the client of a JWT bearer grant request.
+func (c *ClientCredentials) authenticateAssertion(r *http.Request) (*Client, error) {
+	invalid := &OAuthError{Code: "invalid_grant", Description: "the assertion is not valid"}
+	return c.assertionClient(r, r.PostForm.Get("assertion"), invalid)
+}
+
+// authenticateClientAssertion checks the client_assertion a client
+// authenticates with instead of a secret (RFC 7523 section 2.2).
+func (c *ClientCredentials) authenticateClientAssertion(r *http.Request) (*Client, error) {
+	invalid := &OAuthError{Code: "invalid_client", Description: "the client assertion is not valid", Status: http.StatusUnauthorized}
+
+	if r.PostForm.Get("client_assertion_type") != ClientAssertionTypeJWTBearer {
+		return nil, &OAuthError{Code: "invalid_client", Description: "unsupported client assertion type", Status: http.StatusUnauthorized}
+	}
+
+	if _, _, basic := r.BasicAuth(); basic || r.PostForm.Get("client_secret") != "" {
+		return nil, &OAuthError{Code: "invalid_request", Description: "only one client authentication method may be used"}
+	}
+
+	client, err := c.assertionClient(r, r.PostForm.Get("client_assertion"), invalid)
+	if err != nil {
+		return nil, err
+	}
+
+	if id := r.PostForm.Get("client_id"); id != "" && id != client.ID {
+		return nil, invalid
+	}
+	return client, nil
+}
+
+// assertionClient validates an assertion whose iss and sub are the id of a
+// registered client and returns that client, invalid otherwise.
+func (c *ClientCredentials) assertionClient(r *http.Request, assertion string, invalid *OAuthError) (*Client, error) {
+	claims, err := c.Options.Assertions.Validate(r.Context(), assertion)
+	if err != nil {
+		return nil, invalid
+	}
+
+	id, _ := claims["iss"].(string)
+	if claims["sub"] != id {
+		return nil, invalid
+	}
+
+	client, err := c.Options.Clients.Client(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, invalid
+	}
+	return client, nil
+}
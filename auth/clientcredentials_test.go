@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestClientCredentials(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	secretHash, err := crypto.GeneratePasswordHash([]byte("s3cret"))
+	require.NoError(t, err)
+
+	c := NewClientCredentials(ClientCredentialsOptions{
+		Key:    key,
+		Issuer: "https://auth.example.com",
+		Clients: MemoryClientStore{
+			"billing": {
+				ID:         "billing",
+				SecretHash: secretHash,
+				Scopes:     []string{"orders:read", "invoices:write"},
+				Audiences:  []string{"https://orders.example.com", "https://invoices.example.com"},
+			},
+		},
+	})
+
+	request := func(form url.Values, basic ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "https://auth.example.com/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(basic) == 2 {
+			req.SetBasicAuth(basic[0], basic[1])
+		}
+		c.ServeHTTP(w, req)
+		return w
+	}
+
+	parse := func(t *testing.T, w *httptest.ResponseRecorder) (TokenResponse, jwt.MapClaims) {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+		parsed, err := jwt.Parse(resp.AccessToken, func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		require.NoError(t, err)
+		return resp, parsed.Claims.(jwt.MapClaims)
+	}
+
+	oauthError := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		var resp OAuthError
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Code
+	}
+
+	t.Run("ClientSecretBasic", func(t *testing.T) {
+		resp, claims := parse(t, request(url.Values{"grant_type": {"client_credentials"}, "scope": {"orders:read"}}, "billing", "s3cret"))
+		assert.Equal(t, "Bearer", resp.TokenType)
+		assert.Equal(t, "orders:read", resp.Scope)
+		assert.Equal(t, "billing", claims["sub"])
+		assert.Equal(t, "billing", claims["client_id"])
+		assert.Equal(t, "orders:read", claims["scope"])
+		assert.Equal(t, "https://auth.example.com", claims["iss"])
+		assert.Equal(t, []interface{}{"https://orders.example.com", "https://invoices.example.com"}, claims["aud"])
+	})
+
+	t.Run("ClientSecretPost", func(t *testing.T) {
+		resp, claims := parse(t, request(url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {"billing"},
+			"client_secret": {"s3cret"},
+			"audience":      {"https://invoices.example.com"},
+		}))
+		assert.Equal(t, "orders:read invoices:write", resp.Scope)
+		assert.Equal(t, "https://invoices.example.com", claims["aud"])
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"client_credentials"}}, "billing", "guess")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "invalid_client", oauthError(t, w))
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("UnknownClient", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"client_credentials"}, "client_id": {"nobody"}, "client_secret": {"s3cret"}})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "invalid_client", oauthError(t, w))
+	})
+
+	t.Run("BothMethods", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"client_credentials"}, "client_secret": {"s3cret"}}, "billing", "s3cret")
+		assert.Equal(t, "invalid_request", oauthError(t, w))
+	})
+
+	t.Run("ScopeNotAllowed", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"client_credentials"}, "scope": {"orders:write"}}, "billing", "s3cret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "invalid_scope", oauthError(t, w))
+	})
+
+	t.Run("AudienceNotAllowed", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"client_credentials"}, "audience": {"https://admin.example.com"}}, "billing", "s3cret")
+		assert.Equal(t, "invalid_target", oauthError(t, w))
+	})
+
+	t.Run("UnsupportedGrantType", func(t *testing.T) {
+		w := request(url.Values{"grant_type": {"password"}}, "billing", "s3cret")
+		assert.Equal(t, "unsupported_grant_type", oauthError(t, w))
+	})
+}
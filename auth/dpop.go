@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const dpopProofType = "dpop+jwt"
+
+type DPoPOptions struct {
+	// When set, tokens that are not bound to a key with cnf.jkt are rejected.
+	// Otherwise bound tokens require a proof and plain bearer tokens are accepted as before
+	Required bool
+	// How old a proof may be, defaults to one minute
+	MaxAge time.Duration
+	// Allowed clock difference for proofs issued in the future, defaults to five seconds
+	Leeway time.Duration
+	// Where used proof ids are remembered, defaults to NewMemoryNonceStore()
+	Nonces NonceStore
+	// Returns the URL the client used for the request, compared to the htu claim.
+	// Defaults to the scheme, host and path of the request, override it behind a proxy
+	RequestURL func(r *http.Request) string
+}
+
+func (o *DPoPOptions) setDefaults() {
+	if o.MaxAge <= 0 {
+		o.MaxAge = time.Minute
+	}
+
+	if o.Leeway <= 0 {
+		o.Leeway = 5 * time.Second
+	}
+
+	if o.Nonces == nil {
+		o.Nonces = NewMemoryNonceStore()
+	}
+
+	if o.RequestURL == nil {
+		o.RequestURL = requestURL
+	}
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// FromDPoPAuthHeader is a "TokenExtractor" like FromAuthHeader that also
+// accepts the DPoP authorization scheme.
+func FromDPoPAuthHeader(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", nil // No error, just no token
+	}
+
+	authHeaderParts := strings.Fields(authHeader)
+	if len(authHeaderParts) != 2 {
+		return "", errors.New("authorization header format must be Bearer {token} or DPoP {token}")
+	}
+
+	switch strings.ToLower(authHeaderParts[0]) {
+	case "bearer", "dpop":
+		return authHeaderParts[1], nil
+	default:
+		return "", errors.New("authorization header format must be Bearer {token} or DPoP {token}")
+	}
+}
+
+// accessTokenHash is the ath value of a proof for accessToken.
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewDPoPProof creates the DPoP header value a client sends along with a request.
+// Pass the access token to bind the proof to it (ath), or an empty string when
+// requesting a token. key must be an *rsa.PrivateKey or *ecdsa.PrivateKey.
+func NewDPoPProof(key crypto.Signer, method, url, accessToken string) (string, error) {
+	jwk, err := NewJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+
+	signingMethod, err := signingMethodForKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"jti": jti,
+		"htm": method,
+		"htu": url,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = accessTokenHash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = jwk
+	return token.SignedString(key)
+}
+
+// confirmationClaim returns the cnf claim of a token, or nil when it has none.
+func confirmationClaim(claims jwt.MapClaims) map[string]interface{} {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	return cnf
+}
+
+// checkDPoP validates the DPoP proof sent with r for accessToken.
+func (m *JWTMiddleware) checkDPoP(r *http.Request, accessToken string, claims jwt.MapClaims) error {
+	opts := m.Options.DPoP
+
+	jkt, _ := confirmationClaim(claims)["jkt"].(string)
+	if jkt == "" {
+		if opts.Required {
+			return errors.New("token is not bound to a DPoP key")
+		}
+		return nil
+	}
+
+	if scheme := strings.Fields(r.Header.Get("Authorization")); len(scheme) == 0 || strings.ToLower(scheme[0]) != "dpop" {
+		return errors.New("DPoP bound token must use the DPoP authorization scheme")
+	}
+
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return errors.New("exactly one DPoP proof is required")
+	}
+
+	thumbprint, err := validateDPoPProof(opts, proofs[0], r.Method, opts.RequestURL(r), accessToken)
+	if err != nil {
+		return err
+	}
+
+	if thumbprint != jkt {
+		return errors.New("DPoP proof key does not match the token binding")
+	}
+	return nil
+}
+
+// validateDPoPProof checks a proof against the request and returns the thumbprint of its key.
+func validateDPoPProof(opts *DPoPOptions, proof, method, url, accessToken string) (string, error) {
+	var jwk *JWK
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	parsed, err := parser.Parse(proof, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != dpopProofType {
+			return nil, fmt.Errorf("unexpected proof type %v", token.Header["typ"])
+		}
+
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unsupported proof algorithm %v", token.Header["alg"])
+		}
+
+		var err error
+		if jwk, err = ParseJWK(token.Header["jwk"]); err != nil {
+			return nil, err
+		}
+		return jwk.PublicKey()
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "invalid DPoP proof")
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	if htm, _ := claims["htm"].(string); htm != method {
+		return "", errors.New("DPoP proof htm does not match the request method")
+	}
+
+	if htu, _ := claims["htu"].(string); stripQuery(htu) != url {
+		return "", errors.New("DPoP proof htu does not match the request URL")
+	}
+
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return "", errors.New("DPoP proof has no iat")
+	}
+	issuedAt := time.Unix(int64(iat), 0)
+	now := time.Now()
+	if issuedAt.After(now.Add(opts.Leeway)) || issuedAt.Before(now.Add(-opts.MaxAge)) {
+		return "", errors.New("DPoP proof is expired or issued in the future")
+	}
+
+	if accessToken != "" {
+		if ath, _ := claims["ath"].(string); ath != accessTokenHash(accessToken) {
+			return "", errors.New("DPoP proof ath does not match the access token")
+		}
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", errors.New("DPoP proof has no jti")
+	}
+	fresh, err := opts.Nonces.Consume("dpop:"+jwk.Thumbprint()+":"+jti, issuedAt.Add(opts.MaxAge+opts.Leeway))
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", errors.New("DPoP proof has already been used")
+	}
+
+	return jwk.Thumbprint(), nil
+}
+
+// stripQuery drops the query and fragment from a URL, they are not compared for htu.
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestFromDPoPAuthHeader(t *testing.T) {
+	for _, header := range []string{"bearer 123", "DPoP 123"} {
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", header)
+
+		token, err := FromDPoPAuthHeader(req)
+		require.NoError(t, err)
+		assert.Equal(t, "123", token)
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com", nil)
+	req.Header.Set("Authorization", "basic 123")
+	_, err := FromDPoPAuthHeader(req)
+	require.Error(t, err)
+}
+
+func TestJWTMiddleware_DPoP(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+		DPoP: &DPoPOptions{},
+	})
+
+	bound, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1"}, key, IssuerOptions{DPoPKey: &clientKey.PublicKey})
+	require.NoError(t, err)
+
+	bearer, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1"}, key)
+	require.NoError(t, err)
+
+	const url = "https://example.com/resource"
+
+	request := func(scheme, token, proof string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url+"?page=2", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", scheme, token))
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		return w, m.CheckJWT(w, req)
+	}
+
+	t.Run("Bound", func(t *testing.T) {
+		parsed, _ := jwt.Parse(bound, func(token *jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+		thumbprint, err := JWKThumbprint(&clientKey.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, thumbprint, confirmationClaim(parsed.Claims.(jwt.MapClaims))["jkt"])
+	})
+
+	t.Run("ValidProof", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "POST", url, bound)
+		require.NoError(t, err)
+
+		w, err := request("DPoP", bound, proof)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "POST", url, bound)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.NoError(t, err)
+
+		w, err := request("DPoP", bound, proof)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("MissingProof", func(t *testing.T) {
+		_, err := request("DPoP", bound, "")
+		require.Error(t, err)
+	})
+
+	t.Run("BearerScheme", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "POST", url, bound)
+		require.NoError(t, err)
+
+		_, err = request("Bearer", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		proof, err := NewDPoPProof(otherKey, "POST", url, bound)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("WrongMethod", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "GET", url, bound)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("WrongURL", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "POST", "https://example.com/other", bound)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("WrongAccessToken", func(t *testing.T) {
+		proof, err := NewDPoPProof(clientKey, "POST", url, bearer)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		claims := jwt.MapClaims{"jti": "old", "htm": "POST", "htu": url, "iat": time.Now().Add(-time.Hour).Unix(), "ath": accessTokenHash(bound)}
+		jwk, err := NewJWK(&clientKey.PublicKey)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["typ"] = dpopProofType
+		token.Header["jwk"] = jwk
+		proof, err := token.SignedString(clientKey)
+		require.NoError(t, err)
+
+		_, err = request("DPoP", bound, proof)
+		require.Error(t, err)
+	})
+
+	t.Run("UnboundBearer", func(t *testing.T) {
+		w, err := request("Bearer", bearer, "")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		m.Options.DPoP.Required = true
+		defer func() { m.Options.DPoP.Required = false }()
+
+		_, err = request("Bearer", bearer, "")
+		require.Error(t, err)
+	})
+
+	t.Run("DPoPDisabled", func(t *testing.T) {
+		plain := NewJWTMiddleware(JWTOptions{
+			SigningMethod: jwt.SigningMethodRS512,
+			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			},
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", bound))
+		require.Error(t, plain.CheckJWT(w, req))
+	})
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Token exchange (RFC 8693) identifiers.
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// ExchangeRequest describes a token that a service wants to obtain on behalf of
+// the subject of another token.
+type ExchangeRequest struct {
+	// The validated token of the user (or service) being acted for, see JWTFromContext
+	Subject *jwt.Token
+	// Identifier of the calling service, recorded in the act claim
+	Actor string
+	// Audiences the new token is restricted to, at least one is required
+	Audience []string
+	// Scopes for the new token, must be a subset of the subject's. Defaults to all of them
+	Scopes []string
+}
+
+// ExchangePolicy decides whether an exchange is allowed, a non-nil error denies it.
+// The request can be narrowed further by modifying it.
+type ExchangePolicy func(req *ExchangeRequest) error
+
+type TokenExchangerOptions struct {
+	// Key used to sign exchanged tokens
+	Key *rsa.PrivateKey
+	// Value of the iss claim of exchanged tokens
+	Issuer string
+	// Lifetime of exchanged tokens, they never outlive the subject token. Defaults to five minutes
+	TTL time.Duration
+	// Decides which exchanges are allowed
+	Policy ExchangePolicy
+	// Audiences subject tokens must be issued for, at least one has to be in their aud claim.
+	// Subject tokens aren't checked for an audience when empty
+	Audience []string
+	// Used by ServeHTTP to validate the subject_token and actor_token parameters
+	ValidationKeyGetter jwt.Keyfunc
+	// Signing method subject and actor tokens must use, required with ValidationKeyGetter
+	SigningMethod jwt.SigningMethod
+	// Options used for issuing the exchanged tokens
+	IssuerOptions IssuerOptions
+}
+
+// TokenExchanger mints narrower, audience restricted tokens for services calling
+// other services on behalf of a user (RFC 8693). It can be used from Go with
+// Exchange or mounted as a token endpoint.
+type TokenExchanger struct {
+	Options TokenExchangerOptions
+}
+
+func NewTokenExchanger(options TokenExchangerOptions) *TokenExchanger {
+	if options.Key == nil {
+		panic("key must be set")
+	}
+
+	if options.Policy == nil {
+		panic("policy must be set")
+	}
+
+	if options.ValidationKeyGetter != nil && options.SigningMethod == nil {
+		panic("signing method must be set")
+	}
+
+	if options.TTL <= 0 {
+		options.TTL = 5 * time.Minute
+	}
+
+	return &TokenExchanger{options}
+}
+
+// Exchange returns a new signed token for req.
+func (e *TokenExchanger) Exchange(req ExchangeRequest) (*TokenResponse, error) {
+	if req.Subject == nil || !req.Subject.Valid {
+		return nil, &OAuthError{Code: "invalid_request", Description: "a valid subject token is required"}
+	}
+
+	if req.Actor == "" {
+		return nil, &OAuthError{Code: "invalid_request", Description: "the actor is required"}
+	}
+
+	if len(req.Audience) == 0 {
+		return nil, &OAuthError{Code: "invalid_target", Description: "an audience is required"}
+	}
+
+	subject, ok := req.Subject.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, &OAuthError{Code: "invalid_request", Description: "unsupported subject token claims"}
+	}
+
+	if len(e.Options.Audience) > 0 && !containsAny(tokenAudience(subject), e.Options.Audience) {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "the subject token was issued for another audience"}
+	}
+
+	granted := TokenScopes(subject)
+	if len(req.Scopes) == 0 {
+		req.Scopes = granted
+	} else if !HasScopes(granted, req.Scopes...) {
+		return nil, &OAuthError{Code: "invalid_scope", Description: "requested scopes exceed the subject token"}
+	}
+
+	if err := e.Options.Policy(&req); err != nil {
+		return nil, &OAuthError{Code: "access_denied", Description: err.Error(), Status: http.StatusForbidden}
+	}
+
+	// The policy may only narrow the request
+	if len(req.Audience) == 0 {
+		return nil, &OAuthError{Code: "invalid_target", Description: "an audience is required"}
+	}
+	if !HasScopes(granted, req.Scopes...) {
+		return nil, &OAuthError{Code: "invalid_scope", Description: "requested scopes exceed the subject token"}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(e.Options.TTL)
+	if exp, ok := subject["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	act := map[string]interface{}{"sub": req.Actor}
+	if previous, ok := subject["act"].(map[string]interface{}); ok {
+		act["act"] = previous
+	}
+
+	claims := jwt.MapClaims{
+		"sub": subject["sub"],
+		"aud": req.Audience,
+		"act": act,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+		"jti": jti,
+	}
+	if len(req.Audience) == 1 {
+		claims["aud"] = req.Audience[0]
+	}
+	if len(req.Scopes) > 0 {
+		claims["scope"] = strings.Join(req.Scopes, " ")
+	}
+	if e.Options.Issuer != "" {
+		claims["iss"] = e.Options.Issuer
+	}
+
+	token, err := NewJWTWithClaims(claims, e.Options.Key, e.Options.IssuerOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(expiresAt.Sub(now).Seconds()),
+		Scope:           strings.Join(req.Scopes, " "),
+	}, nil
+}
+
+// parseToken validates a token passed as a request parameter.
+func (e *TokenExchanger) parseToken(token, tokenType string) (*jwt.Token, error) {
+	if tokenType != TokenTypeAccessToken && tokenType != TokenTypeJWT {
+		return nil, &OAuthError{Code: "invalid_request", Description: fmt.Sprintf("unsupported token type %q", tokenType)}
+	}
+
+	parsed, err := jwt.Parse(token, e.Options.ValidationKeyGetter)
+	if err != nil || !parsed.Valid {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "the token is not valid"}
+	}
+
+	if e.Options.SigningMethod.Alg() != parsed.Header["alg"] {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "unexpected signing method"}
+	}
+
+	if typ, ok := parsed.Header["typ"].(string); ok && !isAccessTokenType(typ) {
+		return nil, &OAuthError{Code: "invalid_grant", Description: fmt.Sprintf("tokens of type %s can't be exchanged", typ)}
+	}
+	return parsed, nil
+}
+
+// ServeHTTP implements the token exchange grant of a token endpoint. The calling
+// service is identified by the actor_token parameter or, when mounted behind a
+// JWTMiddleware, by the token it authenticated with.
+func (e *TokenExchanger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "token requests must use POST", Status: http.StatusMethodNotAllowed})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "malformed request body"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != GrantTypeTokenExchange {
+		writeOAuthError(w, &OAuthError{Code: "unsupported_grant_type"})
+		return
+	}
+
+	if e.Options.ValidationKeyGetter == nil {
+		writeOAuthError(w, fmt.Errorf("validation key getter must be set"))
+		return
+	}
+
+	subject, err := e.parseToken(r.PostForm.Get("subject_token"), r.PostForm.Get("subject_token_type"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	actorToken := JWTFromContext(r.Context())
+	if raw := r.PostForm.Get("actor_token"); raw != "" {
+		if actorToken, err = e.parseToken(raw, r.PostForm.Get("actor_token_type")); err != nil {
+			writeOAuthError(w, err)
+			return
+		}
+	}
+
+	var actor string
+	if actorToken != nil {
+		if claims, ok := actorToken.Claims.(jwt.MapClaims); ok {
+			actor, _ = claims["sub"].(string)
+		}
+	}
+
+	resp, err := e.Exchange(ExchangeRequest{
+		Subject:  subject,
+		Actor:    actor,
+		Audience: r.PostForm["audience"],
+		Scopes:   strings.Fields(r.PostForm.Get("scope")),
+	})
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
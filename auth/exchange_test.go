@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestTokenExchanger_Exchange(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	keyGetter := func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}
+
+	e := NewTokenExchanger(TokenExchangerOptions{
+		Key:    key,
+		Issuer: "https://auth.example.com",
+		Policy: func(req *ExchangeRequest) error {
+			if req.Actor != "service-a" {
+				return errors.New("only service-a may exchange tokens")
+			}
+			return nil
+		},
+		ValidationKeyGetter: keyGetter,
+		SigningMethod:       jwt.SigningMethodRS512,
+	})
+
+	assert.Panics(t, func() {
+		NewTokenExchanger(TokenExchangerOptions{Key: key, Policy: e.Options.Policy, ValidationKeyGetter: keyGetter})
+	}, "a key getter without a signing method accepts any algorithm")
+
+	subjectToken, err := NewJWTWithClaims(jwt.MapClaims{
+		"sub":   "user-1",
+		"scope": "orders:read orders:write profile",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}, key)
+	require.NoError(t, err)
+
+	subject, err := jwt.Parse(subjectToken, keyGetter)
+	require.NoError(t, err)
+
+	parse := func(t *testing.T, token string) jwt.MapClaims {
+		parsed, err := jwt.Parse(token, keyGetter)
+		require.NoError(t, err)
+		return parsed.Claims.(jwt.MapClaims)
+	}
+
+	t.Run("Narrowed", func(t *testing.T) {
+		resp, err := e.Exchange(ExchangeRequest{
+			Subject:  subject,
+			Actor:    "service-a",
+			Audience: []string{"service-b"},
+			Scopes:   []string{"orders:read"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "orders:read", resp.Scope)
+		assert.Equal(t, TokenTypeAccessToken, resp.IssuedTokenType)
+		assert.InDelta(t, 300, resp.ExpiresIn, 1)
+
+		claims := parse(t, resp.AccessToken)
+		assert.Equal(t, "user-1", claims["sub"])
+		assert.Equal(t, "service-b", claims["aud"])
+		assert.Equal(t, "orders:read", claims["scope"])
+		assert.Equal(t, "https://auth.example.com", claims["iss"])
+		assert.Equal(t, map[string]interface{}{"sub": "service-a"}, claims["act"])
+	})
+
+	t.Run("InheritsScopes", func(t *testing.T) {
+		resp, err := e.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}})
+		require.NoError(t, err)
+		assert.Equal(t, "orders:read orders:write profile", resp.Scope)
+	})
+
+	t.Run("DelegationChain", func(t *testing.T) {
+		first, err := e.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}})
+		require.NoError(t, err)
+
+		delegated, err := jwt.Parse(first.AccessToken, keyGetter)
+		require.NoError(t, err)
+
+		permissive := NewTokenExchanger(TokenExchangerOptions{Key: key, Policy: func(req *ExchangeRequest) error { return nil }})
+		second, err := permissive.Exchange(ExchangeRequest{Subject: delegated, Actor: "service-b", Audience: []string{"service-c"}})
+		require.NoError(t, err)
+
+		claims := parse(t, second.AccessToken)
+		assert.Equal(t, map[string]interface{}{
+			"sub": "service-b",
+			"act": map[string]interface{}{"sub": "service-a"},
+		}, claims["act"])
+	})
+
+	t.Run("BroaderScope", func(t *testing.T) {
+		_, err := e.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}, Scopes: []string{"admin"}})
+		require.Error(t, err)
+		assert.Equal(t, "invalid_scope", err.(*OAuthError).Code)
+	})
+
+	t.Run("NoAudience", func(t *testing.T) {
+		_, err := e.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a"})
+		require.Error(t, err)
+		assert.Equal(t, "invalid_target", err.(*OAuthError).Code)
+	})
+
+	t.Run("PolicyDenied", func(t *testing.T) {
+		_, err := e.Exchange(ExchangeRequest{Subject: subject, Actor: "service-x", Audience: []string{"service-b"}})
+		require.Error(t, err)
+		assert.Equal(t, "access_denied", err.(*OAuthError).Code)
+	})
+
+	t.Run("PolicyWidens", func(t *testing.T) {
+		widening := NewTokenExchanger(TokenExchangerOptions{Key: key, Policy: func(req *ExchangeRequest) error {
+			req.Scopes = append(req.Scopes, "admin")
+			return nil
+		}})
+		_, err := widening.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}})
+		require.Error(t, err)
+		assert.Equal(t, "invalid_scope", err.(*OAuthError).Code)
+
+		clearing := NewTokenExchanger(TokenExchangerOptions{Key: key, Policy: func(req *ExchangeRequest) error {
+			req.Audience = nil
+			return nil
+		}})
+		_, err = clearing.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}})
+		require.Error(t, err)
+		assert.Equal(t, "invalid_target", err.(*OAuthError).Code)
+	})
+
+	t.Run("SubjectAudience", func(t *testing.T) {
+		restricted := NewTokenExchanger(TokenExchangerOptions{
+			Key:      key,
+			Audience: []string{"service-a"},
+			Policy:   func(req *ExchangeRequest) error { return nil },
+		})
+
+		_, err := restricted.Exchange(ExchangeRequest{Subject: subject, Actor: "service-a", Audience: []string{"service-b"}})
+		require.Error(t, err)
+		assert.Equal(t, "invalid_grant", err.(*OAuthError).Code)
+
+		forServiceA, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1", "aud": []string{"service-a", "service-z"}}, key)
+		require.NoError(t, err)
+		parsed, err := jwt.Parse(forServiceA, keyGetter)
+		require.NoError(t, err)
+
+		_, err = restricted.Exchange(ExchangeRequest{Subject: parsed, Actor: "service-a", Audience: []string{"service-b"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("NeverOutlivesSubject", func(t *testing.T) {
+		shortLived, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()}, key)
+		require.NoError(t, err)
+		short, err := jwt.Parse(shortLived, keyGetter)
+		require.NoError(t, err)
+
+		resp, err := e.Exchange(ExchangeRequest{Subject: short, Actor: "service-a", Audience: []string{"service-b"}})
+		require.NoError(t, err)
+		assert.InDelta(t, 60, resp.ExpiresIn, 1)
+	})
+}
+
+func TestTokenExchanger_ServeHTTP(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	keyGetter := func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}
+
+	e := NewTokenExchanger(TokenExchangerOptions{
+		Key:                 key,
+		Policy:              func(req *ExchangeRequest) error { return nil },
+		ValidationKeyGetter: keyGetter,
+		SigningMethod:       jwt.SigningMethodRS512,
+	})
+
+	m := NewJWTMiddleware(JWTOptions{SigningMethod: jwt.SigningMethodRS512, ValidationKeyGetter: keyGetter})
+	handler := m.Handler()(e)
+
+	subjectToken, err := NewJWTWithClaims(jwt.MapClaims{"sub": "user-1", "scope": "read write"}, key)
+	require.NoError(t, err)
+
+	serviceToken, err := NewJWTWithClaims(jwt.MapClaims{"sub": "service-a"}, key)
+	require.NoError(t, err)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "https://auth.example.com/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "bearer "+serviceToken)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Exchanged", func(t *testing.T) {
+		w := post(url.Values{
+			"grant_type":         {GrantTypeTokenExchange},
+			"subject_token":      {subjectToken},
+			"subject_token_type": {TokenTypeAccessToken},
+			"audience":           {"service-b"},
+			"scope":              {"read"},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "read", resp.Scope)
+		assert.Equal(t, "Bearer", resp.TokenType)
+
+		parsed, err := jwt.Parse(resp.AccessToken, keyGetter)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"sub": "service-a"}, parsed.Claims.(jwt.MapClaims)["act"])
+	})
+
+	t.Run("UnsupportedGrant", func(t *testing.T) {
+		w := post(url.Values{"grant_type": {"password"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unsupported_grant_type")
+	})
+
+	t.Run("InvalidSubjectToken", func(t *testing.T) {
+		w := post(url.Values{
+			"grant_type":         {GrantTypeTokenExchange},
+			"subject_token":      {"nope"},
+			"subject_token_type": {TokenTypeAccessToken},
+			"audience":           {"service-b"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_grant")
+	})
+
+	t.Run("InternalTokenType", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{"sub": "user-1", "scope": "read write"})
+		token.Header["typ"] = magicLinkType
+		loginToken, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		w := post(url.Values{
+			"grant_type":         {GrantTypeTokenExchange},
+			"subject_token":      {loginToken},
+			"subject_token_type": {TokenTypeJWT},
+			"audience":           {"service-b"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_grant")
+	})
+}
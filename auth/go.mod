@@ -5,4 +5,5 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0
 	github.com/tizz98/eli/crypto v0.0.0-20190304053131-e2d04ed3cbb6
+	golang.org/x/crypto v0.31.0
 )
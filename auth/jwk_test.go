@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.Thumbprint())
+}
+
+func TestNewJWK(t *testing.T) {
+	t.Run("RSA", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		jwk, err := NewJWK(&key.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, "RSA", jwk.Kty)
+		assert.Equal(t, "AQAB", jwk.E)
+
+		pub, err := jwk.PublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, &key.PublicKey, pub)
+	})
+
+	t.Run("EC", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		jwk, err := NewJWK(&key.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, "EC", jwk.Kty)
+		assert.Equal(t, "P-256", jwk.Crv)
+
+		pub, err := jwk.PublicKey()
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(pub))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := NewJWK("not a key")
+		require.Error(t, err)
+	})
+}
+
+func TestParseJWK(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk, err := NewJWK(&key.PublicKey)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(jwk)
+	require.NoError(t, err)
+
+	t.Run("Bytes", func(t *testing.T) {
+		parsed, err := ParseJWK(raw)
+		require.NoError(t, err)
+		assert.Equal(t, jwk, parsed)
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &m))
+
+		parsed, err := ParseJWK(m)
+		require.NoError(t, err)
+		assert.Equal(t, jwk, parsed)
+	})
+
+	t.Run("PrivateKey", func(t *testing.T) {
+		parsed, err := ParseJWK(`{"kty":"EC","crv":"P-256","x":"a","y":"b","d":"c"}`)
+		require.NoError(t, err)
+
+		_, err = parsed.PublicKey()
+		require.Error(t, err)
+	})
+
+	t.Run("NotOnCurve", func(t *testing.T) {
+		parsed, err := ParseJWK(`{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`)
+		require.NoError(t, err)
+
+		_, err = parsed.PublicKey()
+		require.Error(t, err)
+	})
+}
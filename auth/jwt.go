@@ -1,14 +1,91 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-func NewJWTWithClaims(claims jwt.MapClaims, key *rsa.PrivateKey) (string, error) {
+type IssuerOptions struct {
+	// When set, an audit event is written for every issued token
+	Logger *slog.Logger
+	// When set, audit events contain the raw token instead of a redacted fingerprint
+	LogUnredactedTokens bool
+	// When set, the token is bound to this public key (cnf.jkt) and can only be used
+	// together with a DPoP proof signed by the matching private key
+	DPoPKey crypto.PublicKey
+	// When set, the token is bound to this mTLS client certificate (cnf.x5t#S256) and is
+	// only accepted over a connection authenticated with it
+	ClientCertificate *x509.Certificate
+}
+
+func NewJWTWithClaims(claims jwt.MapClaims, key *rsa.PrivateKey, options ...IssuerOptions) (string, error) {
+	var opts IssuerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if err := bindConfirmation(claims, opts); err != nil {
+		return "", err
+	}
+
 	claims["nbf"] = time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
-	return token.SignedString(key)
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", err
+	}
+
+	attrs := tokenAttrs(claims)
+	attrs = append(attrs, rawTokenAttr(signed, opts.LogUnredactedTokens))
+	audit(context.Background(), opts.Logger, slog.LevelInfo, AuditTokenIssued, attrs...)
+	return signed, nil
+}
+
+// bindConfirmation adds the cnf claim for the key bindings requested in opts.
+func bindConfirmation(claims jwt.MapClaims, opts IssuerOptions) error {
+	cnf := map[string]interface{}{}
+	if opts.DPoPKey != nil {
+		thumbprint, err := JWKThumbprint(opts.DPoPKey)
+		if err != nil {
+			return err
+		}
+		cnf["jkt"] = thumbprint
+	}
+
+	if opts.ClientCertificate != nil {
+		cnf["x5t#S256"] = CertificateThumbprint(opts.ClientCertificate)
+	}
+
+	if len(cnf) > 0 {
+		claims["cnf"] = cnf
+	}
+	return nil
+}
+
+// signingMethodForKey picks the JWS algorithm matching a private key.
+func signingMethodForKey(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().Name {
+		case "P-256":
+			return jwt.SigningMethodES256, nil
+		case "P-384":
+			return jwt.SigningMethodES384, nil
+		case "P-521":
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
 }
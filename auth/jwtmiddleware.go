@@ -5,6 +5,7 @@ package auth
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
@@ -36,6 +37,31 @@ type JWTOptions struct {
 	// If the signing method is not constant the ValidationKeyGetter callback can be used to implement additional checks
 	// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
 	SigningMethod jwt.SigningMethod
+	// When set, an audit event is written for every successful and failed authentication
+	Logger *slog.Logger
+	// When set, audit events contain the raw token instead of a redacted fingerprint.
+	// Never enable this outside of local debugging, the audit log would hold usable credentials.
+	LogUnredactedTokens bool
+	// When set, failed authentications are counted per client IP and clients that fail
+	// too often are answered with 429 Too Many Requests until their lockout expires
+	Limiter *Limiter
+	// Returns the IP address of the client, used for the limiter and audit events.
+	// Defaults to RemoteIP, override it behind a proxy
+	ClientIP func(r *http.Request) string
+	// When set, tokens bound to a key (cnf.jkt) are only accepted together with a valid
+	// DPoP proof (RFC 9449) in the DPoP header
+	DPoP *DPoPOptions
+	// When set, tokens that are not bound to a client certificate (cnf.x5t#S256) are rejected.
+	// Bound tokens are always checked against the certificate of the TLS connection
+	RequireCertificateBinding bool
+	// Audiences tokens must be issued for, at least one has to be in their aud claim.
+	// Tokens aren't checked for an audience when empty, leave it empty only when
+	// every token the key getter accepts is meant for this service
+	Audience []string
+	// When set, PASETO v4 tokens are accepted next to JWTs. Handlers get them from
+	// JWTFromContext like any other token, with the PASETO header as alg. Leave
+	// ValidationKeyGetter and SigningMethod unset to accept PASETO tokens only
+	Paseto *PasetoOptions
 }
 
 type JWTMiddleware struct {
@@ -58,11 +84,27 @@ func NewJWTMiddleware(options ...JWTOptions) *JWTMiddleware {
 		opts.ErrorHandler = OnError
 	}
 
+	if opts.DPoP != nil {
+		dpop := *opts.DPoP
+		dpop.setDefaults()
+		opts.DPoP = &dpop
+
+		if opts.Extractor == nil {
+			opts.Extractor = FromDPoPAuthHeader
+		}
+	}
+
 	if opts.Extractor == nil {
 		opts.Extractor = FromAuthHeader
 	}
 
-	if opts.SigningMethod == nil {
+	if opts.ClientIP == nil {
+		opts.ClientIP = RemoteIP
+	}
+
+	// Without a signing method any algorithm the key getter accepts would do, so
+	// only a middleware for PASETO tokens alone can leave it out
+	if opts.SigningMethod == nil && (opts.ValidationKeyGetter != nil || opts.Paseto == nil) {
 		panic("signing method must be set")
 	}
 
@@ -129,10 +171,15 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	if m.Options.Limiter != nil {
+		if err := m.Options.Limiter.Check(IPKey(m.clientIP(r))); err != nil {
+			return m.rejectLimited(w, r, err)
+		}
+	}
+
 	token, err := m.Options.Extractor(r)
 	if err != nil {
-		m.Options.ErrorHandler(w, r, err.Error())
-		return errors.Wrap(err, "error extracting token")
+		return m.fail(w, r, "", nil, err.Error(), errors.Wrap(err, "error extracting token"))
 	}
 
 	if token == "" {
@@ -140,27 +187,157 @@ func (m *JWTMiddleware) CheckJWT(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
-		m.Options.ErrorHandler(w, r, "Required authorization token not found")
-		return fmt.Errorf("required authorization token not found")
+		return m.fail(w, r, "", nil, "Required authorization token not found", fmt.Errorf("required authorization token not found"))
 	}
 
-	parsed, err := jwt.Parse(token, m.Options.ValidationKeyGetter)
+	parsed, message, err := m.parse(token)
 	if err != nil {
-		m.Options.ErrorHandler(w, r, err.Error())
-		return errors.Wrap(err, "error parsing token")
+		return m.fail(w, r, token, parsed, message, err)
 	}
 
-	if m.Options.SigningMethod != nil && m.Options.SigningMethod.Alg() != parsed.Header["alg"] {
-		message := fmt.Sprintf("Expected %s signing method but token specified %s", m.Options.SigningMethod.Alg(), parsed.Header["alg"])
-		m.Options.ErrorHandler(w, r, message)
-		return fmt.Errorf(message)
+	if err := m.checkBindings(r, token, parsed); err != nil {
+		return m.fail(w, r, token, parsed, err.Error(), err)
+	}
+
+	m.succeed(r, token, parsed)
+	*r = *r.WithContext(context.WithValue(r.Context(), jwtContextKey, parsed))
+	return nil
+}
+
+// parse validates a raw token. On failure it returns the message for the error
+// handler next to the error.
+func (m *JWTMiddleware) parse(token string) (*jwt.Token, string, error) {
+	var parsed *jwt.Token
+	var err error
+	if m.Options.Paseto != nil && IsPaseto(token) {
+		if parsed, err = ParsePaseto(token, *m.Options.Paseto); err != nil {
+			return parsed, err.Error(), errors.Wrap(err, "error parsing token")
+		}
+	} else if m.Options.ValidationKeyGetter == nil {
+		return nil, "Only PASETO tokens are accepted", errors.New("only paseto tokens are accepted")
+	} else {
+		if parsed, err = jwt.Parse(token, m.Options.ValidationKeyGetter); err != nil {
+			return parsed, err.Error(), errors.Wrap(err, "error parsing token")
+		}
+
+		if m.Options.SigningMethod != nil && m.Options.SigningMethod.Alg() != parsed.Header["alg"] {
+			message := fmt.Sprintf("Expected %s signing method but token specified %s", m.Options.SigningMethod.Alg(), parsed.Header["alg"])
+			return parsed, message, errors.New(message)
+		}
+
+		if typ, ok := parsed.Header["typ"].(string); ok && !isAccessTokenType(typ) {
+			message := fmt.Sprintf("Tokens of type %s can't be used for authentication", typ)
+			return parsed, message, errors.New(message)
+		}
 	}
 
 	if !parsed.Valid {
-		m.Options.ErrorHandler(w, r, "The token is not valid")
-		return fmt.Errorf("invalid token")
+		return parsed, "The token is not valid", fmt.Errorf("invalid token")
 	}
 
-	*r = *r.WithContext(context.WithValue(r.Context(), jwtContextKey, parsed))
-	return nil
+	if len(m.Options.Audience) > 0 {
+		claims, _ := parsed.Claims.(jwt.MapClaims)
+		if !containsAny(tokenAudience(claims), m.Options.Audience) {
+			return parsed, "The token was issued for another audience", errors.New("token was issued for another audience")
+		}
+	}
+	return parsed, "", nil
+}
+
+// internalTokenTypes are the typ headers of the tokens this package issues for
+// other purposes than authentication, like login links.
+var internalTokenTypes = map[string]bool{
+	magicLinkType:         true,
+	purposeTokenType:      true,
+	authorizationCodeType: true,
+	loginStateType:        true,
+	dpopProofType:         true,
+}
+
+// isAccessTokenType reports whether a typ header allows a token to be used as
+// an access token. Only the types of tokens issued for other purposes are
+// refused, so that they can't be mistaken for one, any other type is left to
+// the issuer.
+func isAccessTokenType(typ string) bool {
+	return !internalTokenTypes[strings.TrimPrefix(strings.ToLower(typ), "application/")]
+}
+
+// checkBindings verifies that the client presenting a token holds the key the
+// token is bound to.
+func (m *JWTMiddleware) checkBindings(r *http.Request, token string, parsed *jwt.Token) error {
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+
+	if m.Options.DPoP != nil {
+		if err := m.checkDPoP(r, token, claims); err != nil {
+			return err
+		}
+	} else if _, bound := confirmationClaim(claims)["jkt"]; bound {
+		return errors.New("token is bound to a DPoP key but DPoP is not enabled")
+	}
+
+	return m.checkCertificateBinding(r, claims)
+}
+
+// clientIP returns the IP address of the client sending r.
+func (m *JWTMiddleware) clientIP(r *http.Request) string {
+	if m.Options.ClientIP == nil {
+		return RemoteIP(r)
+	}
+	return m.Options.ClientIP(r)
+}
+
+// fail reports a rejected request to the error handler and the audit log, and
+// returns err so CheckJWT can hand it back to the caller.
+func (m *JWTMiddleware) fail(w http.ResponseWriter, r *http.Request, token string, parsed *jwt.Token, message string, err error) error {
+	attrs := []slog.Attr{
+		slog.String("remote_ip", m.clientIP(r)),
+		slog.String("reason", message),
+	}
+	if parsed != nil {
+		if claims, ok := parsed.Claims.(jwt.MapClaims); ok {
+			attrs = append(attrs, tokenAttrs(claims)...)
+		}
+	}
+	if token != "" {
+		attrs = append(attrs, rawTokenAttr(token, m.Options.LogUnredactedTokens))
+	}
+	audit(r.Context(), m.Options.Logger, slog.LevelWarn, AuditAuthFailed, attrs...)
+
+	// Requests without any token are not counted, only actual attempts to authenticate are
+	if m.Options.Limiter != nil && token != "" {
+		if limitErr := m.Options.Limiter.Fail(IPKey(m.clientIP(r))); limitErr != nil {
+			if lockedOut, ok := limitErr.(*LockedOutError); ok {
+				WriteTooManyRequests(w, lockedOut.RetryAfter)
+				return err
+			}
+		}
+	}
+
+	m.Options.ErrorHandler(w, r, message)
+	return err
+}
+
+// rejectLimited answers a request from a client that is locked out by the limiter.
+func (m *JWTMiddleware) rejectLimited(w http.ResponseWriter, r *http.Request, err error) error {
+	audit(r.Context(), m.Options.Logger, slog.LevelWarn, AuditAuthFailed,
+		slog.String("remote_ip", m.clientIP(r)),
+		slog.String("reason", err.Error()),
+	)
+
+	if lockedOut, ok := err.(*LockedOutError); ok {
+		WriteTooManyRequests(w, lockedOut.RetryAfter)
+	} else {
+		m.Options.ErrorHandler(w, r, err.Error())
+	}
+	return errors.Wrap(err, "rate limited")
+}
+
+// succeed writes the audit event for a request that passed authentication.
+func (m *JWTMiddleware) succeed(r *http.Request, token string, parsed *jwt.Token) {
+	attrs := []slog.Attr{slog.String("remote_ip", m.clientIP(r))}
+	if claims, ok := parsed.Claims.(jwt.MapClaims); ok {
+		attrs = append(attrs, tokenAttrs(claims)...)
+	}
+	attrs = append(attrs, rawTokenAttr(token, m.Options.LogUnredactedTokens))
+	audit(r.Context(), m.Options.Logger, slog.LevelInfo, AuditAuthenticated, attrs...)
 }
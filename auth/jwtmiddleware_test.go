@@ -86,6 +86,31 @@ func TestJWTMiddleware_CheckJWT(t *testing.T) {
 		require.Error(t, m.CheckJWT(w, req))
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
+
+	t.Run("Audience", func(t *testing.T) {
+		withAudience := NewJWTMiddleware(JWTOptions{
+			SigningMethod: jwt.SigningMethodRS512,
+			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			},
+			Audience: []string{"api", "admin-api"},
+		})
+
+		check := func(claims jwt.MapClaims) error {
+			token, err := NewJWTWithClaims(claims, key)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://example.com", nil)
+			req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+			return withAudience.CheckJWT(w, req)
+		}
+
+		assert.NoError(t, check(jwt.MapClaims{"aud": "api"}))
+		assert.NoError(t, check(jwt.MapClaims{"aud": []string{"other", "admin-api"}}))
+		assert.Error(t, check(jwt.MapClaims{"aud": "other"}))
+		assert.Error(t, check(jwt.MapClaims{}))
+	})
 }
 
 func TestFromAuthHeader(t *testing.T) {
@@ -170,3 +195,17 @@ func TestFromFirst(t *testing.T) {
 		assert.Equal(t, "", token)
 	})
 }
+
+func TestIsAccessTokenType(t *testing.T) {
+	assert.True(t, isAccessTokenType("JWT"))
+	assert.True(t, isAccessTokenType("at+jwt"))
+	assert.True(t, isAccessTokenType("application/at+jwt"))
+	assert.True(t, isAccessTokenType("JOSE"))
+	assert.True(t, isAccessTokenType("custom+jwt"))
+	assert.False(t, isAccessTokenType("magic-link+jwt"))
+	assert.False(t, isAccessTokenType("application/Magic-Link+JWT"))
+	assert.False(t, isAccessTokenType("purpose+jwt"))
+	assert.False(t, isAccessTokenType("oidc-code+jwt"))
+	assert.False(t, isAccessTokenType("oidc-state+jwt"))
+	assert.False(t, isAccessTokenType("dpop+jwt"))
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// LimiterState is the failure bookkeeping kept for a single key.
+type LimiterState struct {
+	// Failure tokens left in the bucket, a failure is only allowed while this is at least one
+	Tokens float64
+	// When Tokens was last topped up
+	LastRefill time.Time
+	// Number of lockouts since the key last had a full bucket, drives the exponential backoff
+	Lockouts int
+	// The key is rejected until this time
+	LockedUntil time.Time
+	// From this time on the bucket is full and the key isn't locked out, so the
+	// state is the same as none and can be forgotten
+	Expires time.Time
+}
+
+// LimiterStore persists limiter state. Implementations must apply Update
+// atomically per key, the in-memory store is used by default but a shared
+// backend can be plugged in when several instances need to agree. States can
+// be dropped once they expire.
+type LimiterStore interface {
+	// Get loads the state for key, the zero value if none is stored
+	Get(key string) (LimiterState, error)
+	// Update loads the state for key (the zero value if none is stored), passes it to fn and
+	// stores the result.
+	Update(key string, fn func(state *LimiterState)) (LimiterState, error)
+	// Delete forgets the state for key.
+	Delete(key string) error
+}
+
+// limiterSweepInterval is how often the in-memory store drops expired states.
+const limiterSweepInterval = time.Minute
+
+type memoryLimiterStore struct {
+	mu        sync.Mutex
+	states    map[string]LimiterState
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryLimiterStore returns a LimiterStore kept in process memory.
+func NewMemoryLimiterStore() LimiterStore {
+	return newMemoryLimiterStore(time.Now)
+}
+
+func newMemoryLimiterStore(now func() time.Time) *memoryLimiterStore {
+	return &memoryLimiterStore{states: map[string]LimiterState{}, now: now}
+}
+
+func (s *memoryLimiterStore) Get(key string) (LimiterState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key], nil
+}
+
+func (s *memoryLimiterStore) Update(key string, fn func(state *LimiterState)) (LimiterState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+	state := s.states[key]
+	fn(&state)
+	s.states[key] = state
+	return state, nil
+}
+
+// sweep drops expired states, at most once per limiterSweepInterval.
+func (s *memoryLimiterStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < limiterSweepInterval {
+		return
+	}
+
+	s.lastSweep = now
+	for key, state := range s.states {
+		if !state.Expires.IsZero() && !now.Before(state.Expires) {
+			delete(s.states, key)
+		}
+	}
+}
+
+func (s *memoryLimiterStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
+
+// LockedOutError is returned when a key has exhausted its failures and must wait.
+type LockedOutError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts for %s, retry after %s", e.Key, e.RetryAfter)
+}
+
+type LimiterOptions struct {
+	// Number of failures allowed in a burst before a key is locked out, defaults to 5
+	Burst int
+	// How long it takes for a single failure to be forgiven, defaults to one minute
+	RefillInterval time.Duration
+	// Length of the first lockout, every following lockout doubles it. Defaults to one minute
+	BaseLockout time.Duration
+	// Upper bound for the lockout length, defaults to one hour
+	MaxLockout time.Duration
+	// Where the state is kept, defaults to NewMemoryLimiterStore()
+	Store LimiterStore
+	// Clock used for all calculations, defaults to time.Now
+	Now func() time.Time
+}
+
+// Limiter slows down credential stuffing by keeping a token bucket of allowed
+// failures for every key (usually an IP address and an account) and locking the
+// key out with exponential backoff once the bucket is empty.
+type Limiter struct {
+	Options LimiterOptions
+}
+
+func NewLimiter(options ...LimiterOptions) *Limiter {
+	var opts LimiterOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	if opts.Burst <= 0 {
+		opts.Burst = 5
+	}
+
+	if opts.RefillInterval <= 0 {
+		opts.RefillInterval = time.Minute
+	}
+
+	if opts.BaseLockout <= 0 {
+		opts.BaseLockout = time.Minute
+	}
+
+	if opts.MaxLockout <= 0 {
+		opts.MaxLockout = time.Hour
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	if opts.Store == nil {
+		opts.Store = newMemoryLimiterStore(opts.Now)
+	}
+
+	return &Limiter{opts}
+}
+
+// IPKey is the limiter key used for a client address.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// AccountKey is the limiter key used for an account identifier.
+func AccountKey(account string) string {
+	return "account:" + account
+}
+
+// refill tops the bucket up for the time passed since the last refill.
+func (l *Limiter) refill(state *LimiterState, now time.Time) {
+	burst := float64(l.Options.Burst)
+	if state.LastRefill.IsZero() {
+		state.Tokens = burst
+		state.LastRefill = now
+		return
+	}
+
+	elapsed := now.Sub(state.LastRefill)
+	if elapsed <= 0 {
+		return
+	}
+	state.Tokens = math.Min(burst, state.Tokens+float64(elapsed)/float64(l.Options.RefillInterval))
+	state.LastRefill = now
+	if state.Tokens >= burst && !now.Before(state.LockedUntil) {
+		state.Lockouts = 0
+	}
+}
+
+// expiring wraps an update to also set the time the state expires.
+func (l *Limiter) expiring(fn func(state *LimiterState)) func(state *LimiterState) {
+	return func(state *LimiterState) {
+		fn(state)
+
+		missing := float64(l.Options.Burst) - state.Tokens
+		state.Expires = state.LastRefill.Add(time.Duration(missing * float64(l.Options.RefillInterval)))
+		if state.LockedUntil.After(state.Expires) {
+			state.Expires = state.LockedUntil
+		}
+	}
+}
+
+// Check returns a *LockedOutError for the first of keys that is currently
+// locked out. It doesn't store anything, so keys without failures cost nothing.
+func (l *Limiter) Check(keys ...string) error {
+	now := l.Options.Now()
+	for _, key := range keys {
+		state, err := l.Options.Store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		if now.Before(state.LockedUntil) {
+			return &LockedOutError{Key: key, RetryAfter: state.LockedUntil.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// Fail records a failed attempt for every key. A key whose bucket runs empty is
+// locked out, and the returned *LockedOutError tells how long for.
+func (l *Limiter) Fail(keys ...string) error {
+	now := l.Options.Now()
+	var lockedOut *LockedOutError
+
+	for _, key := range keys {
+		state, err := l.Options.Store.Update(key, l.expiring(func(state *LimiterState) {
+			l.refill(state, now)
+			if now.Before(state.LockedUntil) {
+				return
+			}
+
+			state.Tokens--
+			if state.Tokens >= 1 {
+				return
+			}
+
+			lockout := time.Duration(float64(l.Options.BaseLockout) * math.Pow(2, float64(state.Lockouts)))
+			if lockout <= 0 || lockout > l.Options.MaxLockout {
+				lockout = l.Options.MaxLockout
+			}
+			state.Lockouts++
+			state.LockedUntil = now.Add(lockout)
+			state.Tokens = 0
+		}))
+		if err != nil {
+			return err
+		}
+
+		if now.Before(state.LockedUntil) && lockedOut == nil {
+			lockedOut = &LockedOutError{Key: key, RetryAfter: state.LockedUntil.Sub(now)}
+		}
+	}
+
+	if lockedOut != nil {
+		return lockedOut
+	}
+	return nil
+}
+
+// Reset forgets all failures recorded for keys, call it after a successful login.
+func (l *Limiter) Reset(keys ...string) error {
+	for _, key := range keys {
+		if err := l.Options.Store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ComparePasswordHash wraps crypto.ComparePasswordHash with the limiter. The
+// password is not even checked while the account or ip is locked out, failures
+// are recorded against both and a success resets the account.
+func (l *Limiter) ComparePasswordHash(account, ip string, hashedPassword, givenPassword []byte) (bool, error) {
+	keys := []string{AccountKey(account), IPKey(ip)}
+	if err := l.Check(keys...); err != nil {
+		return false, err
+	}
+
+	if !crypto.ComparePasswordHash(hashedPassword, givenPassword) {
+		return false, l.Fail(keys...)
+	}
+
+	return true, l.Reset(AccountKey(account))
+}
+
+// WriteTooManyRequests responds with 429 and a Retry-After header, rounded up to whole seconds.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return NewLimiter(LimiterOptions{
+		Burst:          3,
+		RefillInterval: time.Minute,
+		BaseLockout:    time.Minute,
+		MaxLockout:     5 * time.Minute,
+		Now:            clock.Now,
+	}), clock
+}
+
+func TestLimiter_Fail(t *testing.T) {
+	t.Run("LocksOutAfterBurst", func(t *testing.T) {
+		l, _ := newTestLimiter()
+
+		require.NoError(t, l.Fail("k"))
+		require.NoError(t, l.Fail("k"))
+		err := l.Fail("k")
+		require.Error(t, err)
+
+		lockedOut, ok := err.(*LockedOutError)
+		require.True(t, ok)
+		assert.Equal(t, "k", lockedOut.Key)
+		assert.Equal(t, time.Minute, lockedOut.RetryAfter)
+
+		require.Error(t, l.Check("k"))
+		require.NoError(t, l.Check("other"))
+	})
+
+	t.Run("ExponentialBackoff", func(t *testing.T) {
+		l, clock := newTestLimiter()
+
+		for i := 0; i < 3; i++ {
+			l.Fail("k")
+		}
+		clock.Advance(time.Minute)
+		require.NoError(t, l.Check("k"))
+
+		// one token came back while locked out, so the next failure locks again for twice as long
+		err := l.Fail("k")
+		require.Error(t, err)
+		assert.Equal(t, 2*time.Minute, err.(*LockedOutError).RetryAfter)
+
+		// two tokens came back this time
+		clock.Advance(2 * time.Minute)
+		require.NoError(t, l.Fail("k"))
+		err = l.Fail("k")
+		require.Error(t, err)
+		assert.Equal(t, 4*time.Minute, err.(*LockedOutError).RetryAfter)
+
+		clock.Advance(time.Minute)
+		err = l.Fail("k")
+		require.Error(t, err)
+		assert.Equal(t, 3*time.Minute, err.(*LockedOutError).RetryAfter, "failures while locked out do not extend the lockout")
+
+		// the bucket is full again once the lockout ends, which forgives earlier lockouts
+		clock.Advance(3 * time.Minute)
+		for i := 0; i < 3; i++ {
+			err = l.Fail("k")
+		}
+		require.Error(t, err)
+		assert.Equal(t, time.Minute, err.(*LockedOutError).RetryAfter)
+	})
+
+	t.Run("MaxLockout", func(t *testing.T) {
+		l, clock := newTestLimiter()
+		l.Options.MaxLockout = 90 * time.Second
+
+		for i := 0; i < 3; i++ {
+			l.Fail("k")
+		}
+		clock.Advance(time.Minute)
+
+		err := l.Fail("k")
+		require.Error(t, err)
+		assert.Equal(t, 90*time.Second, err.(*LockedOutError).RetryAfter)
+	})
+
+	t.Run("Refill", func(t *testing.T) {
+		l, clock := newTestLimiter()
+
+		require.NoError(t, l.Fail("k"))
+		require.NoError(t, l.Fail("k"))
+		clock.Advance(time.Minute)
+		require.NoError(t, l.Fail("k"))
+	})
+}
+
+func TestLimiter_Memory(t *testing.T) {
+	l, clock := newTestLimiter()
+	store := l.Options.Store.(*memoryLimiterStore)
+
+	t.Run("CheckDoesNotStore", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, l.Check(IPKey(fmt.Sprintf("10.0.0.%d", i))))
+		}
+		assert.Empty(t, store.states)
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		require.NoError(t, l.Fail("refilled"))
+		for i := 0; i < 3; i++ {
+			l.Fail("locked")
+		}
+		require.Len(t, store.states, 2)
+
+		// the bucket of "refilled" is full again after a minute, "locked" has
+		// an empty bucket that takes three
+		clock.Advance(time.Minute)
+		require.NoError(t, l.Fail("other"))
+		assert.NotContains(t, store.states, "refilled")
+		assert.Contains(t, store.states, "locked")
+
+		clock.Advance(2 * time.Minute)
+		require.NoError(t, l.Fail("other"))
+		assert.NotContains(t, store.states, "locked")
+		require.NoError(t, l.Check("locked"))
+	})
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	for i := 0; i < 3; i++ {
+		l.Fail("k")
+	}
+	require.Error(t, l.Check("k"))
+
+	require.NoError(t, l.Reset("k"))
+	require.NoError(t, l.Check("k"))
+}
+
+func TestLimiter_ComparePasswordHash(t *testing.T) {
+	l, _ := newTestLimiter()
+	hash, err := crypto.GeneratePasswordHash([]byte("foo"))
+	require.NoError(t, err)
+
+	ok, err := l.ComparePasswordHash("alice", "10.0.0.1", hash, []byte("foo"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	for i := 0; i < 2; i++ {
+		ok, err = l.ComparePasswordHash("alice", "10.0.0.1", hash, []byte("bar"))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	ok, err = l.ComparePasswordHash("alice", "10.0.0.1", hash, []byte("bar"))
+	require.Error(t, err)
+	assert.False(t, ok)
+
+	// even the right password is refused while locked out
+	ok, err = l.ComparePasswordHash("alice", "10.0.0.2", hash, []byte("foo"))
+	require.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestWriteTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteTooManyRequests(w, 1500*time.Millisecond)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestJWTMiddleware_Limiter(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	l, clock := newTestLimiter()
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+		Limiter: l,
+	})
+
+	token, err := NewJWTWithClaims(jwt.MapClaims{}, key)
+	require.NoError(t, err)
+
+	request := func(authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		m.CheckJWT(w, req)
+		return w
+	}
+
+	t.Run("MissingTokenNotCounted", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusUnauthorized, request("").Code)
+		}
+	})
+
+	t.Run("LockedOut", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("bearer bad").Code)
+		assert.Equal(t, http.StatusUnauthorized, request("bearer bad").Code)
+
+		w := request("bearer bad")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+		w = request(fmt.Sprintf("bearer %s", token))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("LockoutExpires", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		assert.Equal(t, http.StatusOK, request(fmt.Sprintf("bearer %s", token)).Code)
+	})
+
+	t.Run("ClientIP", func(t *testing.T) {
+		proxied := NewJWTMiddleware(JWTOptions{
+			SigningMethod: jwt.SigningMethodRS512,
+			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			},
+			Limiter: l,
+			ClientIP: func(r *http.Request) string {
+				return r.Header.Get("X-Forwarded-For")
+			},
+		})
+
+		// Every request comes from the proxy, the clients are told apart by the header
+		request := func(clientIP string) int {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://example.com", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", clientIP)
+			req.Header.Set("Authorization", "bearer bad")
+			proxied.CheckJWT(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1"))
+		assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1"))
+		assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1"))
+		assert.Equal(t, http.StatusUnauthorized, request("192.0.2.2"))
+	})
+}
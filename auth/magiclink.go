@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// magicLinkType is the typ header of login tokens, it keeps them from being
+// accepted as access tokens.
+const magicLinkType = "magic-link+jwt"
+
+type MagicLinkOptions struct {
+	// Key used to sign login tokens and the access tokens they are redeemed for
+	Key *rsa.PrivateKey
+	// Page the link points to, the token is added as a query parameter
+	URL string
+	// Name of the query parameter holding the token, defaults to "token"
+	Param string
+	// How long a link can be used, defaults to 15 minutes
+	TTL time.Duration
+	// Where used links are remembered, defaults to NewMemoryNonceStore()
+	Nonces NonceStore
+	// Value of the iss claim of login tokens
+	Issuer string
+	// Lifetime of the access tokens issued by Redeem, defaults to one hour
+	AccessTokenTTL time.Duration
+	// Options used for issuing access tokens in Redeem
+	IssuerOptions IssuerOptions
+}
+
+// MagicLinks issues and verifies single-use, short-lived login links for
+// passwordless email login. The link should land on a page that submits the
+// token with a POST, so that link scanners in mail clients don't use it up.
+type MagicLinks struct {
+	Options MagicLinkOptions
+}
+
+func NewMagicLinks(options MagicLinkOptions) *MagicLinks {
+	if options.Key == nil {
+		panic("key must be set")
+	}
+
+	if options.URL == "" {
+		panic("url must be set")
+	}
+
+	if options.Param == "" {
+		options.Param = "token"
+	}
+
+	if options.TTL <= 0 {
+		options.TTL = 15 * time.Minute
+	}
+
+	if options.AccessTokenTTL <= 0 {
+		options.AccessTokenTTL = time.Hour
+	}
+
+	if options.Nonces == nil {
+		options.Nonces = NewMemoryNonceStore()
+	}
+
+	return &MagicLinks{options}
+}
+
+// NewToken returns a login token for email.
+func (m *MagicLinks) NewToken(email string) (string, error) {
+	if email == "" {
+		return "", errors.New("email is required")
+	}
+
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"email": email,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(m.Options.TTL).Unix(),
+	}
+	if m.Options.Issuer != "" {
+		claims["iss"] = m.Options.Issuer
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+	token.Header["typ"] = magicLinkType
+	return token.SignedString(m.Options.Key)
+}
+
+// NewLink returns the login URL to email to the user.
+func (m *MagicLinks) NewLink(email string) (string, error) {
+	token, err := m.NewToken(email)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.Options.URL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(m.Options.Param, token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify checks a login token and uses it up, returning the email address it
+// was issued for. A token can only be verified once.
+func (m *MagicLinks) Verify(token string) (string, error) {
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS512 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		if token.Header["typ"] != magicLinkType {
+			return nil, errors.New("not a login token")
+		}
+		return &m.Options.Key.PublicKey, nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "invalid login token")
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	email, _ := claims["email"].(string)
+	nonce, _ := claims["nonce"].(string)
+	exp, _ := claims["exp"].(float64)
+	if email == "" || nonce == "" || exp == 0 {
+		return "", errors.New("invalid login token: missing claims")
+	}
+
+	if m.Options.Issuer != "" && !claims.VerifyIssuer(m.Options.Issuer, true) {
+		return "", errors.New("invalid login token: unexpected issuer")
+	}
+
+	fresh, err := m.Options.Nonces.Consume("magic-link:"+nonce, time.Unix(int64(exp), 0))
+	if err != nil {
+		return "", err
+	}
+
+	if !fresh {
+		return "", errors.New("login token has already been used")
+	}
+	return email, nil
+}
+
+// Redeem verifies a login token and issues an access token for it with
+// NewJWTWithClaims. The sub and email claims default to the email address, iat
+// and exp to now and AccessTokenTTL from now. claims is not modified.
+func (m *MagicLinks) Redeem(token string, claims jwt.MapClaims) (string, error) {
+	email, err := m.Verify(token)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	accessClaims := jwt.MapClaims{
+		"sub":   email,
+		"email": email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(m.Options.AccessTokenTTL).Unix(),
+	}
+	for name, value := range claims {
+		accessClaims[name] = value
+	}
+
+	return NewJWTWithClaims(accessClaims, m.Options.Key, m.Options.IssuerOptions)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestMagicLinks(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	links := NewMagicLinks(MagicLinkOptions{
+		Key:    key,
+		URL:    "https://app.example.com/login/verify?next=%2Fhome",
+		Issuer: "https://app.example.com",
+	})
+
+	tokenFromLink := func(t *testing.T, link string) string {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+		assert.Equal(t, "/home", u.Query().Get("next"))
+		return u.Query().Get("token")
+	}
+
+	t.Run("Verify", func(t *testing.T) {
+		link, err := links.NewLink("alice@example.com")
+		require.NoError(t, err)
+
+		email, err := links.Verify(tokenFromLink(t, link))
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.com", email)
+	})
+
+	t.Run("SingleUse", func(t *testing.T) {
+		token, err := links.NewToken("alice@example.com")
+		require.NoError(t, err)
+
+		_, err = links.Verify(token)
+		require.NoError(t, err)
+
+		_, err = links.Verify(token)
+		require.Error(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+			"email": "alice@example.com",
+			"nonce": "expired",
+			"iss":   "https://app.example.com",
+			"exp":   time.Now().Add(-time.Minute).Unix(),
+		})
+		token.Header["typ"] = magicLinkType
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		_, err = links.Verify(signed)
+		require.Error(t, err)
+	})
+
+	t.Run("AccessTokenIsNotALoginToken", func(t *testing.T) {
+		accessToken, err := NewJWTWithClaims(jwt.MapClaims{"email": "alice@example.com", "nonce": "x", "exp": time.Now().Add(time.Minute).Unix()}, key)
+		require.NoError(t, err)
+
+		_, err = links.Verify(accessToken)
+		require.Error(t, err)
+	})
+
+	t.Run("LoginTokenIsNotAnAccessToken", func(t *testing.T) {
+		m := NewJWTMiddleware(JWTOptions{
+			SigningMethod: jwt.SigningMethodRS512,
+			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			},
+		})
+
+		token, err := links.NewToken("alice@example.com")
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		require.Error(t, m.CheckJWT(w, req))
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		token, err := links.NewToken("alice@example.com")
+		require.NoError(t, err)
+
+		extra := jwt.MapClaims{"role": "user"}
+		accessToken, err := links.Redeem(token, extra)
+		require.NoError(t, err)
+		assert.Equal(t, jwt.MapClaims{"role": "user"}, extra, "the claims passed in are not modified")
+
+		parsed, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		require.NoError(t, err)
+		claims := parsed.Claims.(jwt.MapClaims)
+		assert.Equal(t, "alice@example.com", claims["sub"])
+		assert.Equal(t, "alice@example.com", claims["email"])
+		assert.Equal(t, "user", claims["role"])
+		require.Contains(t, claims, "exp")
+		assert.InDelta(t, time.Now().Add(time.Hour).Unix(), claims["exp"], 5)
+		assert.Contains(t, claims, "iat")
+
+		_, err = links.Redeem(token, nil)
+		require.Error(t, err, "the login token was used up")
+
+		t.Run("NoClaims", func(t *testing.T) {
+			token, err := links.NewToken("bob@example.com")
+			require.NoError(t, err)
+
+			accessToken, err := links.Redeem(token, nil)
+			require.NoError(t, err)
+
+			parsed, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			require.NoError(t, err)
+			assert.Contains(t, parsed.Claims.(jwt.MapClaims), "exp")
+		})
+	})
+}
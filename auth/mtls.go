@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of a
+// certificate as used by the cnf.x5t#S256 claim (RFC 8705).
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// checkCertificateBinding compares the cnf.x5t#S256 claim with the client
+// certificate of the TLS connection the token was presented over.
+func (m *JWTMiddleware) checkCertificateBinding(r *http.Request, claims jwt.MapClaims) error {
+	x5t, _ := confirmationClaim(claims)["x5t#S256"].(string)
+	if x5t == "" {
+		if m.Options.RequireCertificateBinding {
+			return errors.New("token is not bound to a client certificate")
+		}
+		return nil
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.New("token is bound to a client certificate but none was presented")
+	}
+
+	thumbprint := CertificateThumbprint(r.TLS.PeerCertificates[0])
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(x5t)) != 1 {
+		return errors.New("client certificate does not match the token binding")
+	}
+	return nil
+}
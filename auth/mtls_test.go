@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// newTestCertificate creates a self-signed client certificate.
+func newTestCertificate(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestJWTMiddleware_CertificateBinding(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+	})
+
+	server := httptest.NewUnstartedServer(m.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	clientCert := newTestCertificate(t, "service-a")
+	otherCert := newTestCertificate(t, "service-b")
+
+	bound, err := NewJWTWithClaims(jwt.MapClaims{"sub": "service-a"}, key, IssuerOptions{ClientCertificate: clientCert.Leaf})
+	require.NoError(t, err)
+
+	unbound, err := NewJWTWithClaims(jwt.MapClaims{"sub": "service-a"}, key)
+	require.NoError(t, err)
+
+	request := func(cert *tls.Certificate, token string) int {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: transport}
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Thumbprint", func(t *testing.T) {
+		parsed, _ := jwt.Parse(bound, func(token *jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+		cnf := confirmationClaim(parsed.Claims.(jwt.MapClaims))
+		assert.Equal(t, CertificateThumbprint(clientCert.Leaf), cnf["x5t#S256"])
+	})
+
+	t.Run("SameCertificate", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(&clientCert, bound))
+	})
+
+	t.Run("OtherCertificate", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(&otherCert, bound))
+	})
+
+	t.Run("NoCertificate", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(nil, bound))
+	})
+
+	t.Run("UnboundToken", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(&clientCert, unbound))
+
+		m.Options.RequireCertificateBinding = true
+		defer func() { m.Options.RequireCertificateBinding = false }()
+
+		assert.Equal(t, http.StatusUnauthorized, request(&clientCert, unbound))
+	})
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers single-use values (proof ids, token ids, nonces) until
+// they expire so that they can't be replayed. The in-memory store only works
+// for a single instance, a shared backend is needed when running several.
+type NonceStore interface {
+	// Consume marks nonce as used until expiresAt and reports whether this is the first use.
+	Consume(nonce string, expiresAt time.Time) (bool, error)
+}
+
+// nonceSweepInterval is how often the in-memory store drops expired nonces.
+const nonceSweepInterval = time.Minute
+
+type memoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryNonceStore returns a NonceStore kept in process memory. Expired
+// nonces are dropped as new ones are consumed.
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}, now: time.Now}
+}
+
+func (s *memoryNonceStore) Consume(nonce string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if exp, used := s.nonces[nonce]; used && !now.After(exp) {
+		return false, nil
+	}
+	s.nonces[nonce] = expiresAt
+	return true, nil
+}
+
+// sweep drops expired nonces, at most once per nonceSweepInterval.
+func (s *memoryNonceStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < nonceSweepInterval {
+		return
+	}
+
+	s.lastSweep = now
+	for n, exp := range s.nonces {
+		if now.After(exp) {
+			delete(s.nonces, n)
+		}
+	}
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
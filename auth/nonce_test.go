@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryNonceStore_Consume(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryNonceStore().(*memoryNonceStore)
+	store.now = clock.Now
+
+	fresh, err := store.Consume("a", clock.now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = store.Consume("a", clock.now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	fresh, err = store.Consume("b", clock.now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	clock.Advance(2 * time.Minute)
+	fresh, err = store.Consume("c", clock.now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	assert.Len(t, store.nonces, 1, "expired nonces are dropped")
+	t.Run("SweepInterval", func(t *testing.T) {
+		fresh, err := store.Consume("d", clock.now.Add(time.Second))
+		require.NoError(t, err)
+		assert.True(t, fresh)
+
+		// Expired but not swept yet, it can be used again all the same
+		clock.Advance(2 * time.Second)
+		assert.Len(t, store.nonces, 2)
+		fresh, err = store.Consume("d", clock.now.Add(time.Second))
+		require.NoError(t, err)
+		assert.True(t, fresh)
+
+		fresh, err = store.Consume("d", clock.now.Add(time.Second))
+		require.NoError(t, err)
+		assert.False(t, fresh)
+	})
+}
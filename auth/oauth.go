@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// OAuthError is an error response as defined by RFC 6749 section 5.2.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// HTTP status of the response, defaults to 400
+	Status int `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// TokenResponse is a successful token endpoint response.
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeOAuthError writes err as an RFC 6749 error response, errors that are
+// not an *OAuthError are reported as server_error without details.
+func writeOAuthError(w http.ResponseWriter, err error) {
+	oauthErr, ok := err.(*OAuthError)
+	if !ok {
+		oauthErr = &OAuthError{Code: "server_error", Status: http.StatusInternalServerError}
+	}
+
+	status := oauthErr.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, oauthErr)
+}
+
+// TokenScopes returns the scopes granted by a token, read from the space
+// separated scope claim or the scp array.
+func TokenScopes(claims jwt.MapClaims) []string {
+	switch scope := claims["scope"].(type) {
+	case string:
+		return strings.Fields(scope)
+	}
+
+	var scopes []string
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+// HasScopes reports whether granted contains every scope in required.
+func HasScopes(granted []string, required ...string) bool {
+	return containsAll(granted, required)
+}
+
+func containsAll(values []string, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range values {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenAudience returns the aud claim, which may be a string or an array.
+func tokenAudience(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var audience []string
+		for _, a := range aud {
+			if a, ok := a.(string); ok {
+				audience = append(audience, a)
+			}
+		}
+		return audience
+	case []string:
+		return aud
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenScopes(t *testing.T) {
+	t.Run("Scope", func(t *testing.T) {
+		assert.Equal(t, []string{"read", "write"}, TokenScopes(jwt.MapClaims{"scope": "read  write"}))
+	})
+
+	t.Run("Scp", func(t *testing.T) {
+		assert.Equal(t, []string{"read"}, TokenScopes(jwt.MapClaims{"scp": []interface{}{"read", 1}}))
+	})
+
+	t.Run("None", func(t *testing.T) {
+		assert.Empty(t, TokenScopes(jwt.MapClaims{}))
+	})
+}
+
+func TestHasScopes(t *testing.T) {
+	assert.True(t, HasScopes([]string{"read", "write"}, "write"))
+	assert.True(t, HasScopes([]string{"read"}))
+	assert.False(t, HasScopes([]string{"read"}, "read", "write"))
+}
+
+func TestWriteOAuthError(t *testing.T) {
+	t.Run("OAuthError", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		writeOAuthError(w, &OAuthError{Code: "invalid_grant", Description: "nope"})
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error":"invalid_grant","error_description":"nope"}`, w.Body.String())
+	})
+
+	t.Run("OtherError", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		writeOAuthError(w, errors.New("database is down"))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":"server_error"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,549 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// GrantTypeAuthorizationCode is the grant_type of RFC 6749 section 4.1.
+const GrantTypeAuthorizationCode = "authorization_code"
+
+// Paths of the provider endpoints, relative to the issuer.
+const (
+	OIDCDiscoveryPath = "/.well-known/openid-configuration"
+	OIDCAuthorizePath = "/authorize"
+	OIDCTokenPath     = "/token"
+	OIDCUserInfoPath  = "/userinfo"
+	OIDCJWKSPath      = "/jwks"
+)
+
+// authorizationCodeType is the typ header of authorization codes, it keeps them
+// from being accepted as access tokens.
+const authorizationCodeType = "oidc-code+jwt"
+
+// oidcScopeClaims are the claims each standard scope gives access to (OpenID Connect Core section 5.4).
+var oidcScopeClaims = map[string][]string{
+	"profile": {"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at"},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// scopeClaims returns the claims of a user that scopes give access to.
+func scopeClaims(claims map[string]interface{}, scopes []string) map[string]interface{} {
+	filtered := map[string]interface{}{}
+	for _, scope := range scopes {
+		for _, name := range oidcScopeClaims[scope] {
+			if value, ok := claims[name]; ok {
+				filtered[name] = value
+			}
+		}
+	}
+	return filtered
+}
+
+// UserStore connects the provider to the application's users.
+type UserStore interface {
+	// Authenticated returns the user logged in on r and when they logged in, an
+	// empty subject when nobody is logged in
+	Authenticated(r *http.Request) (sub string, authTime time.Time, err error)
+	// Claims returns the standard claims of a user (name, email, ...), the
+	// provider only hands out those the granted scopes give access to
+	Claims(ctx context.Context, sub string) (map[string]interface{}, error)
+}
+
+type OIDCProviderOptions struct {
+	// URL the provider is reachable at, without a trailing slash. Mount the
+	// provider with http.StripPrefix when the URL has a path
+	Issuer string
+	// Key ID tokens, access tokens and authorization codes are signed with
+	Key *rsa.PrivateKey
+	// kid of Key in the JWKS, defaults to its JWK thumbprint
+	KeyID string
+	Users UserStore
+	// Registered clients, authorization requests must use one of their RedirectURIs
+	Clients ClientStore
+	// Where users are sent to log in, with the URL to return to in the return_to
+	// parameter. When not set authorization requests without a user fail with login_required
+	LoginURL string
+	// How long authorization codes can be redeemed, defaults to one minute
+	CodeTTL time.Duration
+	// Lifetime of ID and access tokens, defaults to one hour
+	TTL time.Duration
+	// Where redeemed authorization codes are remembered, defaults to NewMemoryNonceStore()
+	Codes NonceStore
+	// Options used for issuing access tokens
+	IssuerOptions IssuerOptions
+}
+
+// OIDCProvider is a small OpenID Connect provider for internal apps, local
+// development and integration tests: discovery, JWKS, the authorization code
+// flow with PKCE, ID tokens and userinfo. Authorization codes are signed, not
+// encrypted, and carry the subject.
+type OIDCProvider struct {
+	Options OIDCProviderOptions
+
+	mux *http.ServeMux
+}
+
+func NewOIDCProvider(options OIDCProviderOptions) *OIDCProvider {
+	if options.Issuer == "" {
+		panic("issuer must be set")
+	}
+
+	if options.Key == nil {
+		panic("key must be set")
+	}
+
+	if options.Users == nil {
+		panic("users must be set")
+	}
+
+	if options.Clients == nil {
+		panic("clients must be set")
+	}
+
+	options.Issuer = strings.TrimSuffix(options.Issuer, "/")
+
+	if options.KeyID == "" {
+		kid, err := JWKThumbprint(&options.Key.PublicKey)
+		if err != nil {
+			panic(err)
+		}
+		options.KeyID = kid
+	}
+
+	if options.CodeTTL <= 0 {
+		options.CodeTTL = time.Minute
+	}
+
+	if options.TTL <= 0 {
+		options.TTL = time.Hour
+	}
+
+	if options.Codes == nil {
+		options.Codes = NewMemoryNonceStore()
+	}
+
+	p := &OIDCProvider{Options: options, mux: http.NewServeMux()}
+	p.mux.HandleFunc(OIDCDiscoveryPath, p.discovery)
+	p.mux.HandleFunc(OIDCJWKSPath, p.jwks)
+	p.mux.HandleFunc(OIDCAuthorizePath, p.authorize)
+	p.mux.HandleFunc(OIDCTokenPath, p.token)
+	p.mux.HandleFunc(OIDCUserInfoPath, p.userInfo)
+	return p
+}
+
+func (p *OIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// OIDCDiscovery is the provider metadata document (OpenID Connect Discovery section 3).
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+}
+
+// Discovery returns the metadata served at OIDCDiscoveryPath.
+func (p *OIDCProvider) Discovery() *OIDCDiscovery {
+	claims := []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash"}
+	for _, scope := range []string{"profile", "email", "address", "phone"} {
+		claims = append(claims, oidcScopeClaims[scope]...)
+	}
+
+	return &OIDCDiscovery{
+		Issuer:                            p.Options.Issuer,
+		AuthorizationEndpoint:             p.Options.Issuer + OIDCAuthorizePath,
+		TokenEndpoint:                     p.Options.Issuer + OIDCTokenPath,
+		UserInfoEndpoint:                  p.Options.Issuer + OIDCUserInfoPath,
+		JWKSURI:                           p.Options.Issuer + OIDCJWKSPath,
+		ScopesSupported:                   []string{"openid", "profile", "email", "address", "phone"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   claims,
+	}
+}
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.Discovery())
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+func (p *OIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, err := NewJWK(&p.Options.Key.PublicKey)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	jwk.Kid = p.Options.KeyID
+	jwk.Use = "sig"
+	writeJSON(w, http.StatusOK, &JWKS{Keys: []*JWK{jwk}})
+}
+
+// PKCEChallenge returns the S256 code_challenge for a code_verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// redirectError sends an authorization error back to the client.
+func (p *OIDCProvider) redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state string, err *OAuthError) {
+	query := url.Values{"error": {err.Code}, "iss": {p.Options.Issuer}}
+	if err.Description != "" {
+		query.Set("error_description", err.Description)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, query), http.StatusFound)
+}
+
+func appendQuery(u string, query url.Values) string {
+	if strings.Contains(u, "?") {
+		return u + "&" + query.Encode()
+	}
+	return u + "?" + query.Encode()
+}
+
+func (p *OIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "malformed request"})
+		return
+	}
+
+	// Errors are only redirected once the redirect URI is known to belong to the client
+	client, err := p.Options.Clients.Client(r.Context(), r.Form.Get("client_id"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	if client == nil {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "unknown client"})
+		return
+	}
+
+	redirectURI := r.Form.Get("redirect_uri")
+	if !containsAll(client.RedirectURIs, []string{redirectURI}) {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "redirect_uri is not registered for the client"})
+		return
+	}
+
+	state := r.Form.Get("state")
+	scopes := strings.Fields(r.Form.Get("scope"))
+	switch {
+	case r.Form.Get("response_type") != "code":
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "unsupported_response_type"})
+		return
+	case !HasScopes(scopes, "openid"):
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "invalid_scope", Description: "the openid scope is required"})
+		return
+	case r.Form.Get("code_challenge") == "":
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "invalid_request", Description: "code_challenge is required"})
+		return
+	case r.Form.Get("code_challenge_method") != "S256":
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "invalid_request", Description: "code_challenge_method must be S256"})
+		return
+	}
+
+	sub, authTime, err := p.Options.Users.Authenticated(r)
+	if err != nil {
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "server_error"})
+		return
+	}
+
+	if sub == "" {
+		if p.Options.LoginURL == "" || r.Form.Get("prompt") == "none" {
+			p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "login_required"})
+			return
+		}
+
+		returnTo := p.Options.Issuer + OIDCAuthorizePath + "?" + r.Form.Encode()
+		http.Redirect(w, r, appendQuery(p.Options.LoginURL, url.Values{"return_to": {returnTo}}), http.StatusFound)
+		return
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "server_error"})
+		return
+	}
+
+	now := time.Now()
+	code := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+		"iss":            p.Options.Issuer,
+		"sub":            sub,
+		"client_id":      client.ID,
+		"redirect_uri":   redirectURI,
+		"scope":          strings.Join(scopes, " "),
+		"nonce":          r.Form.Get("nonce"),
+		"auth_time":      authTime.Unix(),
+		"code_challenge": r.Form.Get("code_challenge"),
+		"jti":            jti,
+		"exp":            now.Add(p.Options.CodeTTL).Unix(),
+	})
+	code.Header["typ"] = authorizationCodeType
+
+	signed, err := code.SignedString(p.Options.Key)
+	if err != nil {
+		p.redirectError(w, r, redirectURI, state, &OAuthError{Code: "server_error"})
+		return
+	}
+
+	query := url.Values{"code": {signed}, "iss": {p.Options.Issuer}}
+	if state != "" {
+		query.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, query), http.StatusFound)
+}
+
+// authenticateTokenClient authenticates confidential clients with their secret
+// and identifies public clients by their client_id.
+func (p *OIDCProvider) authenticateTokenClient(r *http.Request) (*Client, error) {
+	if _, _, basic := r.BasicAuth(); basic || r.PostForm.Get("client_secret") != "" {
+		return authenticateClient(r.Context(), p.Options.Clients, r)
+	}
+
+	client, err := p.Options.Clients.Client(r.Context(), r.PostForm.Get("client_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil || !client.Public {
+		return nil, &OAuthError{Code: "invalid_client", Description: "client authentication failed", Status: http.StatusUnauthorized}
+	}
+	return client, nil
+}
+
+// redeemCode validates an authorization code and uses it up.
+func (p *OIDCProvider) redeemCode(client *Client, code, redirectURI, verifier string) (jwt.MapClaims, error) {
+	invalid := &OAuthError{Code: "invalid_grant", Description: "the authorization code is not valid"}
+
+	parsed, err := jwt.Parse(code, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS512 || token.Header["typ"] != authorizationCodeType {
+			return nil, errors.New("not an authorization code")
+		}
+		return &p.Options.Key.PublicKey, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, invalid
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	if claims["client_id"] != client.ID || claims["redirect_uri"] != redirectURI {
+		return nil, invalid
+	}
+
+	challenge, _ := claims["code_challenge"].(string)
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) != 1 {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "code_verifier does not match the code_challenge"}
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	fresh, err := p.Options.Codes.Consume("oidc-code:"+jti, time.Unix(int64(exp), 0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !fresh {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "the authorization code has already been used"}
+	}
+	return claims, nil
+}
+
+// signIDToken signs an ID token with RS256, the algorithm every relying party supports.
+func (p *OIDCProvider) signIDToken(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.Options.KeyID
+
+	signed, err := token.SignedString(p.Options.Key)
+	if err != nil {
+		return "", err
+	}
+
+	attrs := tokenAttrs(claims)
+	attrs = append(attrs, rawTokenAttr(signed, p.Options.IssuerOptions.LogUnredactedTokens))
+	audit(context.Background(), p.Options.IssuerOptions.Logger, slog.LevelInfo, AuditTokenIssued, attrs...)
+	return signed, nil
+}
+
+// atHash is the at_hash of an access token for an RS256 ID token.
+func atHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "token requests must use POST", Status: http.StatusMethodNotAllowed})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "malformed request body"})
+		return
+	}
+
+	client, err := p.authenticateTokenClient(r)
+	if err != nil {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuthError(w, err)
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != GrantTypeAuthorizationCode {
+		writeOAuthError(w, &OAuthError{Code: "unsupported_grant_type"})
+		return
+	}
+
+	code, err := p.redeemCode(client, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	sub, _ := code["sub"].(string)
+	scope, _ := code["scope"].(string)
+	now := time.Now()
+	exp := now.Add(p.Options.TTL).Unix()
+
+	accessToken, err := NewJWTWithClaims(jwt.MapClaims{
+		"iss":       p.Options.Issuer,
+		"sub":       sub,
+		"aud":       p.Options.Issuer,
+		"client_id": client.ID,
+		"scope":     scope,
+		"iat":       now.Unix(),
+		"exp":       exp,
+		"jti":       jti,
+	}, p.Options.Key, p.Options.IssuerOptions)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	user, err := p.Options.Users.Claims(r.Context(), sub)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	idClaims := jwt.MapClaims(scopeClaims(user, strings.Fields(scope)))
+	idClaims["iss"] = p.Options.Issuer
+	idClaims["sub"] = sub
+	idClaims["aud"] = client.ID
+	idClaims["iat"] = now.Unix()
+	idClaims["exp"] = exp
+	idClaims["auth_time"] = code["auth_time"]
+	idClaims["at_hash"] = atHash(accessToken)
+	if nonce, _ := code["nonce"].(string); nonce != "" {
+		idClaims["nonce"] = nonce
+	}
+
+	idToken, err := p.signIDToken(idClaims)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(p.Options.TTL.Seconds()),
+		Scope:       scope,
+		IDToken:     idToken,
+	})
+}
+
+func (p *OIDCProvider) userInfo(w http.ResponseWriter, r *http.Request) {
+	invalid := func(description string) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description))
+		writeOAuthError(w, &OAuthError{Code: "invalid_token", Description: description, Status: http.StatusUnauthorized})
+	}
+
+	raw, err := FromAuthHeader(r)
+	if err != nil || raw == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeOAuthError(w, &OAuthError{Code: "invalid_request", Description: "an access token is required", Status: http.StatusUnauthorized})
+		return
+	}
+
+	parsed, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS512 {
+			return nil, errors.New("unexpected signing method")
+		}
+		if typ, ok := token.Header["typ"].(string); ok && !isAccessTokenType(typ) {
+			return nil, errors.New("not an access token")
+		}
+		return &p.Options.Key.PublicKey, nil
+	})
+	if err != nil || !parsed.Valid {
+		invalid("the access token is not valid")
+		return
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(p.Options.Issuer, true) || !claims.VerifyAudience(p.Options.Issuer, true) {
+		invalid("the access token was not issued by this provider")
+		return
+	}
+
+	scopes := TokenScopes(claims)
+	if !HasScopes(scopes, "openid") {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeOAuthError(w, &OAuthError{Code: "insufficient_scope", Status: http.StatusForbidden})
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	user, err := p.Options.Users.Claims(r.Context(), sub)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	info := scopeClaims(user, scopes)
+	info["sub"] = sub
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// headerUserStore logs in whoever is named in the X-User header.
+type headerUserStore map[string]map[string]interface{}
+
+var testAuthTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func (s headerUserStore) Authenticated(r *http.Request) (string, time.Time, error) {
+	return r.Header.Get("X-User"), testAuthTime, nil
+}
+
+func (s headerUserStore) Claims(ctx context.Context, sub string) (map[string]interface{}, error) {
+	return s[sub], nil
+}
+
+func newTestOIDCProvider(t *testing.T) (*OIDCProvider, *httptest.Server) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	secretHash, err := crypto.GeneratePasswordHash([]byte("s3cret"))
+	require.NoError(t, err)
+
+	var provider *OIDCProvider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	provider = NewOIDCProvider(OIDCProviderOptions{
+		Issuer: server.URL,
+		Key:    key,
+		Users: headerUserStore{
+			"alice": {"name": "Alice", "email": "alice@example.com", "email_verified": true, "role": "admin"},
+		},
+		Clients: MemoryClientStore{
+			"web": {ID: "web", SecretHash: secretHash, RedirectURIs: []string{"https://app.example.com/callback"}},
+			"spa": {ID: "spa", Public: true, RedirectURIs: []string{"http://localhost:3000/callback"}},
+		},
+		LoginURL: "https://login.example.com/",
+	})
+	return provider, server
+}
+
+func TestOIDCProvider(t *testing.T) {
+	provider, server := newTestOIDCProvider(t)
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	verifier := "dBjftJeZ4CVP-mJ92K9VJwPdPxo3T6bgHbBzAkv8hW8"
+	authorize := func(t *testing.T, user string, params url.Values) *url.URL {
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"web"},
+			"redirect_uri":          {"https://app.example.com/callback"},
+			"scope":                 {"openid email"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {PKCEChallenge(verifier)},
+			"code_challenge_method": {"S256"},
+		}
+		for name, values := range params {
+			query[name] = values
+		}
+
+		req, err := http.NewRequest("GET", server.URL+OIDCAuthorizePath+"?"+query.Encode(), nil)
+		require.NoError(t, err)
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return location
+	}
+
+	exchange := func(t *testing.T, form url.Values) (*http.Response, map[string]interface{}) {
+		req, err := http.NewRequest("POST", server.URL+OIDCTokenPath, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("web", "s3cret")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp, body
+	}
+
+	t.Run("Discovery", func(t *testing.T) {
+		resp, err := http.Get(server.URL + OIDCDiscoveryPath)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var discovery OIDCDiscovery
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&discovery))
+		assert.Equal(t, server.URL, discovery.Issuer)
+		assert.Equal(t, server.URL+OIDCTokenPath, discovery.TokenEndpoint)
+		assert.Equal(t, []string{"S256"}, discovery.CodeChallengeMethodsSupported)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		resp, err := http.Get(server.URL + OIDCJWKSPath)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var jwks JWKS
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&jwks))
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, provider.Options.KeyID, jwks.Keys[0].Kid)
+
+		pub, err := jwks.Keys[0].PublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, &provider.Options.Key.PublicKey, pub)
+	})
+
+	t.Run("AuthorizationCodeFlow", func(t *testing.T) {
+		location := authorize(t, "alice", nil)
+		assert.Equal(t, "app.example.com", location.Host)
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+		assert.Equal(t, server.URL, location.Query().Get("iss"))
+		code := location.Query().Get("code")
+		require.NotEmpty(t, code)
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example.com/callback"},
+			"code_verifier": {verifier},
+		}
+		resp, body := exchange(t, form)
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		accessToken := body["access_token"].(string)
+
+		idToken, err := jwt.Parse(body["id_token"].(string), func(token *jwt.Token) (interface{}, error) {
+			assert.Equal(t, provider.Options.KeyID, token.Header["kid"])
+			return &provider.Options.Key.PublicKey, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, jwt.SigningMethodRS256.Alg(), idToken.Header["alg"])
+
+		claims := idToken.Claims.(jwt.MapClaims)
+		assert.Equal(t, server.URL, claims["iss"])
+		assert.Equal(t, "alice", claims["sub"])
+		assert.Equal(t, "web", claims["aud"])
+		assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+		assert.Equal(t, float64(testAuthTime.Unix()), claims["auth_time"])
+		assert.Equal(t, atHash(accessToken), claims["at_hash"])
+		assert.Equal(t, "alice@example.com", claims["email"])
+		assert.NotContains(t, claims, "name")
+		assert.NotContains(t, claims, "role")
+
+		t.Run("CodeReused", func(t *testing.T) {
+			resp, body := exchange(t, form)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, "invalid_grant", body["error"])
+		})
+
+		t.Run("UserInfo", func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL+OIDCUserInfoPath, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var info map[string]interface{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+			assert.Equal(t, map[string]interface{}{"sub": "alice", "email": "alice@example.com", "email_verified": true}, info)
+		})
+
+		t.Run("UserInfoWithIDToken", func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL+OIDCUserInfoPath, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+body["id_token"].(string))
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		})
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		code := authorize(t, "alice", nil).Query().Get("code")
+		resp, body := exchange(t, url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example.com/callback"},
+			"code_verifier": {"wrong"},
+		})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("PublicClient", func(t *testing.T) {
+		code := authorize(t, "alice", url.Values{"client_id": {"spa"}, "redirect_uri": {"http://localhost:3000/callback"}}).Query().Get("code")
+
+		resp, err := http.PostForm(server.URL+OIDCTokenPath, url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {"spa"},
+			"code":          {code},
+			"redirect_uri":  {"http://localhost:3000/callback"},
+			"code_verifier": {verifier},
+		})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("NotLoggedIn", func(t *testing.T) {
+		location := authorize(t, "", nil)
+		assert.Equal(t, "login.example.com", location.Host)
+		assert.Contains(t, location.Query().Get("return_to"), server.URL+OIDCAuthorizePath)
+
+		location = authorize(t, "", url.Values{"prompt": {"none"}})
+		assert.Equal(t, "login_required", location.Query().Get("error"))
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+	})
+
+	t.Run("MissingPKCE", func(t *testing.T) {
+		location := authorize(t, "alice", url.Values{"code_challenge": {""}})
+		assert.Equal(t, "invalid_request", location.Query().Get("error"))
+	})
+
+	t.Run("UnregisteredRedirectURI", func(t *testing.T) {
+		req := httptest.NewRequest("GET", server.URL+OIDCAuthorizePath+"?client_id=web&redirect_uri=https://evil.example.com/", nil)
+		w := httptest.NewRecorder()
+		provider.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+	})
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// ErrOTPReused is returned for a code of a time step at or before the last one a code was accepted for.
+var ErrOTPReused = errors.New("one-time password has already been used")
+
+var otpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateOTPSecret returns a new random 160 bit secret, base32 encoded as
+// authenticator apps expect it.
+func GenerateOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return otpEncoding.EncodeToString(secret), nil
+}
+
+func otpHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported otp algorithm %q", algorithm)
+	}
+}
+
+// HOTP computes the RFC 4226 one-time password for counter.
+func HOTP(secret []byte, counter uint64, digits int, h func() hash.Hash) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(h, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(value)%mod)
+}
+
+type TOTPOptions struct {
+	// Base32 encoded shared secret, see GenerateOTPSecret
+	Secret string
+	// Shown by authenticator apps, usually the service name
+	Issuer string
+	// Shown by authenticator apps, usually the user's email address
+	AccountName string
+	// Length of the codes, 6 to 8, defaults to 6
+	Digits int
+	// How long a code is valid for, a whole number of seconds, defaults to 30 seconds
+	Period time.Duration
+	// HMAC hash, one of SHA1 (the default), SHA256 or SHA512
+	Algorithm string
+	// How many periods before and after the current one are accepted to allow for clock drift,
+	// defaults to 1. Set it to a negative number to only accept the current period
+	Skew int
+	// Where used time steps are remembered so a code can't be used twice. Share one store
+	// between all TOTP values, defaults to a new NewMemoryNonceStore()
+	UsedSteps NonceStore
+	// Clock used to find the current time step, defaults to time.Now
+	Now func() time.Time
+}
+
+// TOTP generates and validates RFC 6238 time-based one-time passwords for a single secret.
+type TOTP struct {
+	Options TOTPOptions
+}
+
+func NewTOTP(options TOTPOptions) *TOTP {
+	if options.Digits == 0 {
+		options.Digits = 6
+	} else if options.Digits < 6 || options.Digits > 8 {
+		panic("otp codes must have 6 to 8 digits")
+	}
+
+	if options.Period == 0 {
+		options.Period = 30 * time.Second
+	} else if options.Period < time.Second || options.Period%time.Second != 0 {
+		panic("otp period must be a whole number of seconds")
+	}
+
+	if options.Skew == 0 {
+		options.Skew = 1
+	} else if options.Skew < 0 {
+		options.Skew = 0
+	}
+
+	if options.UsedSteps == nil {
+		options.UsedSteps = NewMemoryNonceStore()
+	}
+
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return &TOTP{options}
+}
+
+func (t *TOTP) secret() ([]byte, error) {
+	secret := strings.ToUpper(strings.Replace(t.Options.Secret, " ", "", -1))
+	decoded, err := otpEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(decoded) == 0 {
+		return nil, errors.New("otp secret must be base32 encoded")
+	}
+	return decoded, nil
+}
+
+func (t *TOTP) step(at time.Time) uint64 {
+	return uint64(at.Unix()) / uint64(t.Options.Period/time.Second)
+}
+
+// Code returns the code for the time step containing at.
+func (t *TOTP) Code(at time.Time) (string, error) {
+	secret, err := t.secret()
+	if err != nil {
+		return "", err
+	}
+
+	h, err := otpHash(t.Options.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(secret, t.step(at), t.Options.Digits, h), nil
+}
+
+func (t *TOTP) usedStepKey(step uint64) string {
+	return "totp:" + RedactToken(t.Options.Secret) + ":" + strconv.FormatUint(step, 10)
+}
+
+// usedStepExpiry is the end of the last time step whose window still accepts
+// codes of step, until then it must be remembered as used.
+func (t *TOTP) usedStepExpiry(step uint64) time.Time {
+	periodSeconds := uint64(t.Options.Period / time.Second)
+	return time.Unix(int64((step+uint64(t.Options.Skew)+1)*periodSeconds), 0)
+}
+
+// Validate checks code against the current time step and the allowed drift.
+// A code is only accepted once, and neither are codes of earlier time steps
+// once a code was accepted, those return ErrOTPReused.
+func (t *TOTP) Validate(code string) (bool, error) {
+	secret, err := t.secret()
+	if err != nil {
+		return false, err
+	}
+
+	h, err := otpHash(t.Options.Algorithm)
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != t.Options.Digits {
+		return false, nil
+	}
+
+	now := t.Options.Now()
+	current := t.step(now)
+	for offset := -t.Options.Skew; offset <= t.Options.Skew; offset++ {
+		step := current + uint64(offset)
+		if offset < 0 && current < uint64(-offset) {
+			continue
+		}
+
+		expected := HOTP(secret, step, t.Options.Digits, h)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		fresh, err := t.Options.UsedSteps.Consume(t.usedStepKey(step), t.usedStepExpiry(step))
+		if err != nil {
+			return false, err
+		}
+
+		if !fresh {
+			return false, ErrOTPReused
+		}
+
+		// The earlier steps of the window are used up too, older ones are never accepted again anyway
+		first := uint64(0)
+		if current > uint64(t.Options.Skew) {
+			first = current - uint64(t.Options.Skew)
+		}
+		for earlier := first; earlier < step; earlier++ {
+			if _, err := t.Options.UsedSteps.Consume(t.usedStepKey(earlier), t.usedStepExpiry(earlier)); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// ProvisioningURI returns the otpauth:// URI to show as a QR code to the user.
+func (t *TOTP) ProvisioningURI() string {
+	label := t.Options.AccountName
+	if t.Options.Issuer != "" {
+		label = t.Options.Issuer + ":" + label
+	}
+
+	query := url.Values{}
+	query.Set("secret", strings.TrimRight(t.Options.Secret, "="))
+	if t.Options.Issuer != "" {
+		query.Set("issuer", t.Options.Issuer)
+	}
+	if t.Options.Algorithm != "" {
+		query.Set("algorithm", strings.ToUpper(t.Options.Algorithm))
+	}
+	query.Set("digits", strconv.Itoa(t.Options.Digits))
+	query.Set("period", strconv.Itoa(int(t.Options.Period/time.Second)))
+
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}
+	return u.String()
+}
+
+const recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// normalizeRecoveryCode makes recovery codes insensitive to case and separators.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
+
+// GenerateRecoveryCodes returns n recovery codes to show to the user once, and
+// their hashes (crypto.GeneratePasswordHash) to store.
+func GenerateRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, n)
+	hashes := make([][]byte, n)
+
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		for j, b := range raw {
+			raw[j] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+		}
+		codes[i] = string(raw[:5]) + "-" + string(raw[5:])
+
+		hashed, err := crypto.GeneratePasswordHash([]byte(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = hashed
+	}
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode returns the index of the hash matching code, or -1. The
+// matching hash must be removed by the caller so the code can't be used again.
+func VerifyRecoveryCode(hashes [][]byte, code string) int {
+	normalized := []byte(normalizeRecoveryCode(code))
+	for i, hashed := range hashes {
+		if crypto.ComparePasswordHash(hashed, normalized) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TokenAMR returns the authentication methods (RFC 8176) of a token's amr claim.
+func TokenAMR(claims jwt.MapClaims) []string {
+	var methods []string
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, m := range amr {
+			if m, ok := m.(string); ok {
+				methods = append(methods, m)
+			}
+		}
+	}
+	return methods
+}
+
+// RequireAMR returns a middleware that only lets requests through whose token
+// (see JWTFromContext) lists all of methods in its amr claim, "mfa" when no
+// methods are given. It must be used after JWTMiddleware.Handler.
+func RequireAMR(methods ...string) func(http.Handler) http.Handler {
+	if len(methods) == 0 {
+		methods = []string{"mfa"}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := JWTFromContext(r.Context())
+			if token != nil {
+				if claims, ok := token.Claims.(jwt.MapClaims); ok && containsAll(TokenAMR(claims), methods) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="A second authentication factor is required"`)
+			http.Error(w, "A second authentication factor is required", http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestHOTP(t *testing.T) {
+	// Test values from RFC 4226 appendix D
+	secret := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		assert.Equal(t, code, HOTP(secret, uint64(counter), 6, sha1.New))
+	}
+}
+
+func TestTOTP_Code(t *testing.T) {
+	// Test values from RFC 6238 appendix B
+	secrets := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	vectors := []struct {
+		unix int64
+		code map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+
+	for _, v := range vectors {
+		for algorithm, code := range v.code {
+			totp := NewTOTP(TOTPOptions{
+				Secret:    base32.StdEncoding.EncodeToString([]byte(secrets[algorithm])),
+				Digits:    8,
+				Algorithm: algorithm,
+			})
+
+			actual, err := totp.Code(time.Unix(v.unix, 0))
+			require.NoError(t, err)
+			assert.Equal(t, code, actual, "%s at %d", algorithm, v.unix)
+		}
+	}
+}
+
+func TestTOTP_Validate(t *testing.T) {
+	secret, err := GenerateOTPSecret()
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: time.Now()}
+	totp := NewTOTP(TOTPOptions{Secret: secret, Now: clock.Now})
+
+	codeAt := func(offset time.Duration) string {
+		code, err := totp.Code(clock.now.Add(offset))
+		require.NoError(t, err)
+		return code
+	}
+
+	t.Run("Current", func(t *testing.T) {
+		ok, err := totp.Validate(codeAt(0))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("Reused", func(t *testing.T) {
+		ok, err := totp.Validate(codeAt(0))
+		assert.Equal(t, ErrOTPReused, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Drift", func(t *testing.T) {
+		drifting := NewTOTP(TOTPOptions{Secret: secret, Now: clock.Now})
+		ok, err := drifting.Validate(codeAt(-30 * time.Second))
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = drifting.Validate(codeAt(30 * time.Second))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("Earlier", func(t *testing.T) {
+		// Codes of steps before the last accepted one are refused, even inside the window
+		ok, err := totp.Validate(codeAt(-30 * time.Second))
+		assert.Equal(t, ErrOTPReused, err)
+		assert.False(t, ok)
+
+		later := NewTOTP(TOTPOptions{Secret: secret, Now: clock.Now})
+		ok, err = later.Validate(codeAt(30 * time.Second))
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		for _, offset := range []time.Duration{-30 * time.Second, 0} {
+			ok, err = later.Validate(codeAt(offset))
+			assert.Equal(t, ErrOTPReused, err)
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("ReplayLater", func(t *testing.T) {
+		// The code of the next step, accepted at the start of this one, is still
+		// in the window (Skew+1)*Period later
+		stepClock := &fakeClock{now: time.Unix(1600000020, 0)}
+		store := NewMemoryNonceStore().(*memoryNonceStore)
+		store.now = stepClock.Now
+		replayed := NewTOTP(TOTPOptions{Secret: secret, Now: stepClock.Now, UsedSteps: store})
+
+		code, err := replayed.Code(stepClock.now.Add(30 * time.Second))
+		require.NoError(t, err)
+		ok, err := replayed.Validate(code)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		stepClock.Advance(61 * time.Second)
+		ok, err = replayed.Validate(code)
+		assert.Equal(t, ErrOTPReused, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("OutsideWindow", func(t *testing.T) {
+		ok, err := totp.Validate(codeAt(-90 * time.Second))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("NoSkew", func(t *testing.T) {
+		strict := NewTOTP(TOTPOptions{Secret: secret, Now: clock.Now, Skew: -1})
+		ok, err := strict.Validate(codeAt(-60 * time.Second))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Wrong", func(t *testing.T) {
+		ok, err := totp.Validate("12345")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		assert.Panics(t, func() { NewTOTP(TOTPOptions{Secret: secret, Period: 500 * time.Millisecond}) })
+		assert.Panics(t, func() { NewTOTP(TOTPOptions{Secret: secret, Period: 1500 * time.Millisecond}) })
+		assert.Panics(t, func() { NewTOTP(TOTPOptions{Secret: secret, Digits: 10}) })
+		assert.Panics(t, func() { NewTOTP(TOTPOptions{Secret: secret, Digits: 4}) })
+	})
+
+	t.Run("InvalidSecret", func(t *testing.T) {
+		_, err := NewTOTP(TOTPOptions{Secret: "not base32!"}).Validate("123456")
+		require.Error(t, err)
+	})
+}
+
+func TestTOTP_ProvisioningURI(t *testing.T) {
+	totp := NewTOTP(TOTPOptions{Secret: "JBSWY3DPEHPK3PXP", Issuer: "Example", AccountName: "alice@example.com"})
+
+	u, err := url.Parse(totp.ProvisioningURI())
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Example:alice@example.com", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "Example", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+	require.Len(t, hashes, 3)
+
+	for i, code := range codes {
+		assert.Len(t, code, 11)
+		assert.NotContains(t, string(hashes[i]), code)
+	}
+
+	assert.Equal(t, 1, VerifyRecoveryCode(hashes, codes[1]))
+	assert.Equal(t, 2, VerifyRecoveryCode(hashes, strings.ToLower(strings.Replace(codes[2], "-", " ", 1))))
+	assert.Equal(t, -1, VerifyRecoveryCode(hashes, "AAAAA-AAAAA"))
+}
+
+func TestRequireAMR(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+	})
+	handler := m.Handler()(RequireAMR()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	request := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		token, err := NewJWTWithClaims(claims, key)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com/settings", nil)
+		req.Header.Set("Authorization", "bearer "+token)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Completed", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, request(jwt.MapClaims{"amr": []string{"pwd", "otp", "mfa"}}).Code)
+	})
+
+	t.Run("PasswordOnly", func(t *testing.T) {
+		w := request(jwt.MapClaims{"amr": []string{"pwd"}})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "insufficient_user_authentication")
+	})
+
+	t.Run("NoAMR", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(jwt.MapClaims{}).Code)
+	})
+}
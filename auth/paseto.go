@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4 headers, they double as the alg header of the *jwt.Token handed to
+// handlers for PASETO tokens.
+const (
+	PasetoV4Public = "v4.public"
+	PasetoV4Local  = "v4.local"
+)
+
+// PasetoLocalKeySize is the size of v4.local keys.
+const PasetoLocalKeySize = 32
+
+var (
+	ErrPasetoInvalid = errors.New("paseto token is not valid")
+)
+
+// Claims holding a date, JWTs store them as numbers and PASETO as RFC 3339 strings.
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+// pae is the pre-authentication encoding of PASETO.
+func pae(pieces ...[]byte) []byte {
+	var buf []byte
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(pieces)))
+	buf = append(buf, n[:]...)
+	for _, piece := range pieces {
+		binary.LittleEndian.PutUint64(n[:], uint64(len(piece)))
+		buf = append(buf, n[:]...)
+		buf = append(buf, piece...)
+	}
+	return buf
+}
+
+func pasetoEncode(header string, body, footer []byte) string {
+	token := header + "." + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+// pasetoDecode splits a token with the given header into its body and footer.
+func pasetoDecode(header, token string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(token, header+".") {
+		return nil, nil, ErrPasetoInvalid
+	}
+
+	parts := strings.Split(token[len(header)+1:], ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrPasetoInvalid
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrPasetoInvalid
+	}
+
+	var footer []byte
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, ErrPasetoInvalid
+		}
+	}
+	return body, footer, nil
+}
+
+// PasetoV4Sign creates a v4.public token for message.
+func PasetoV4Sign(key ed25519.PrivateKey, message, footer, implicit []byte) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", errors.New("paseto private key must be 64 bytes")
+	}
+
+	header := []byte(PasetoV4Public + ".")
+	sig := ed25519.Sign(key, pae(header, message, footer, implicit))
+	return pasetoEncode(PasetoV4Public, append(append([]byte{}, message...), sig...), footer), nil
+}
+
+// PasetoV4Verify checks a v4.public token and returns its message and footer.
+func PasetoV4Verify(key ed25519.PublicKey, token string, implicit []byte) ([]byte, []byte, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, nil, errors.New("paseto public key must be 32 bytes")
+	}
+
+	body, footer, err := pasetoDecode(PasetoV4Public, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, ErrPasetoInvalid
+	}
+
+	message, sig := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	header := []byte(PasetoV4Public + ".")
+	if !ed25519.Verify(key, pae(header, message, footer, implicit), sig) {
+		return nil, nil, ErrPasetoInvalid
+	}
+	return message, footer, nil
+}
+
+// pasetoV4LocalKeys derives the encryption key, counter nonce and authentication key for nonce.
+func pasetoV4LocalKeys(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	if len(key) != PasetoLocalKeySize {
+		return nil, nil, nil, errors.New("paseto local key must be 32 bytes")
+	}
+
+	h, err := blake2b.New(56, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+
+	a, err := blake2b.New256(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	a.Write([]byte("paseto-auth-key-for-aead"))
+	a.Write(nonce)
+	return tmp[:32], tmp[32:], a.Sum(nil), nil
+}
+
+func pasetoV4LocalTag(authKey, nonce, ciphertext, footer, implicit []byte) ([]byte, error) {
+	mac, err := blake2b.New256(authKey)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(pae([]byte(PasetoV4Local+"."), nonce, ciphertext, footer, implicit))
+	return mac.Sum(nil), nil
+}
+
+// PasetoV4Encrypt creates a v4.local token for message.
+func PasetoV4Encrypt(key, message, footer, implicit []byte) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return pasetoV4Encrypt(key, nonce, message, footer, implicit)
+}
+
+func pasetoV4Encrypt(key, nonce, message, footer, implicit []byte) (string, error) {
+	encryptionKey, counterNonce, authKey, err := pasetoV4LocalKeys(key, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+
+	tag, err := pasetoV4LocalTag(authKey, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return "", err
+	}
+
+	body := append(append(append([]byte{}, nonce...), ciphertext...), tag...)
+	return pasetoEncode(PasetoV4Local, body, footer), nil
+}
+
+// PasetoV4Decrypt checks a v4.local token and returns its message and footer.
+func PasetoV4Decrypt(key []byte, token string, implicit []byte) ([]byte, []byte, error) {
+	body, footer, err := pasetoDecode(PasetoV4Local, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < 32+32 {
+		return nil, nil, ErrPasetoInvalid
+	}
+	nonce, ciphertext, tag := body[:32], body[32:len(body)-32], body[len(body)-32:]
+
+	encryptionKey, counterNonce, authKey, err := pasetoV4LocalKeys(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected, err := pasetoV4LocalTag(authKey, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, nil, ErrPasetoInvalid
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, footer, nil
+}
+
+// pasetoPayload prepares claims for a PASETO token: numeric dates become RFC
+// 3339 strings as the PASETO spec requires.
+func pasetoPayload(claims jwt.MapClaims, opts IssuerOptions) ([]byte, error) {
+	if err := bindConfirmation(claims, opts); err != nil {
+		return nil, err
+	}
+
+	claims["nbf"] = time.Now()
+
+	payload := make(map[string]interface{}, len(claims))
+	for name, value := range claims {
+		payload[name] = value
+	}
+
+	for _, name := range pasetoTimeClaims {
+		switch value := payload[name].(type) {
+		case int64:
+			payload[name] = time.Unix(value, 0).UTC().Format(time.RFC3339)
+		case int:
+			payload[name] = time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+		case float64:
+			payload[name] = time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+		case time.Time:
+			payload[name] = value.UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(payload)
+}
+
+// pasetoClaims decodes a PASETO payload into the claims shape handlers get for
+// JWTs, RFC 3339 dates are turned back into numbers.
+func pasetoClaims(payload []byte) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrPasetoInvalid
+	}
+
+	for _, name := range pasetoTimeClaims {
+		value, ok := claims[name].(string)
+		if !ok {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.Errorf("paseto %s claim is not a valid date", name)
+		}
+		claims[name] = float64(t.Unix())
+	}
+	return claims, nil
+}
+
+func issuePaseto(token string, claims jwt.MapClaims, opts IssuerOptions) string {
+	attrs := tokenAttrs(claims)
+	attrs = append(attrs, rawTokenAttr(token, opts.LogUnredactedTokens))
+	audit(context.Background(), opts.Logger, slog.LevelInfo, AuditTokenIssued, attrs...)
+	return token
+}
+
+// NewPasetoV4PublicWithClaims is the PASETO v4.public (Ed25519 signed)
+// counterpart of NewJWTWithClaims.
+func NewPasetoV4PublicWithClaims(claims jwt.MapClaims, key ed25519.PrivateKey, options ...IssuerOptions) (string, error) {
+	var opts IssuerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	payload, err := pasetoPayload(claims, opts)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := PasetoV4Sign(key, payload, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return issuePaseto(token, claims, opts), nil
+}
+
+// NewPasetoV4LocalWithClaims is the PASETO v4.local (encrypted) counterpart of
+// NewJWTWithClaims. key must be PasetoLocalKeySize bytes.
+func NewPasetoV4LocalWithClaims(claims jwt.MapClaims, key []byte, options ...IssuerOptions) (string, error) {
+	var opts IssuerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	payload, err := pasetoPayload(claims, opts)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := PasetoV4Encrypt(key, payload, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return issuePaseto(token, claims, opts), nil
+}
+
+type PasetoOptions struct {
+	// Key v4.public tokens are verified with, they are rejected when not set
+	PublicKey ed25519.PublicKey
+	// Key v4.local tokens are decrypted with, they are rejected when not set
+	LocalKey []byte
+}
+
+// IsPaseto reports whether a raw token is a PASETO v4 token.
+func IsPaseto(token string) bool {
+	return strings.HasPrefix(token, PasetoV4Public+".") || strings.HasPrefix(token, PasetoV4Local+".")
+}
+
+// ParsePaseto verifies or decrypts a PASETO v4 token and returns it in the same
+// form as a parsed JWT, so handlers can use JWTFromContext for both. The alg
+// header of the returned token is the PASETO version and purpose.
+func ParsePaseto(token string, opts PasetoOptions) (*jwt.Token, error) {
+	var payload []byte
+	var err error
+	var header string
+
+	switch {
+	case strings.HasPrefix(token, PasetoV4Public+"."):
+		if opts.PublicKey == nil {
+			return nil, errors.New("v4.public tokens are not accepted")
+		}
+		header = PasetoV4Public
+		payload, _, err = PasetoV4Verify(opts.PublicKey, token, nil)
+	case strings.HasPrefix(token, PasetoV4Local+"."):
+		if opts.LocalKey == nil {
+			return nil, errors.New("v4.local tokens are not accepted")
+		}
+		header = PasetoV4Local
+		payload, _, err = PasetoV4Decrypt(opts.LocalKey, token, nil)
+	default:
+		return nil, errors.New("unsupported paseto version or purpose")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := pasetoClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &jwt.Token{
+		Raw:    token,
+		Header: map[string]interface{}{"alg": header},
+		Claims: claims,
+	}
+	if err := claims.Valid(); err != nil {
+		return parsed, err
+	}
+	parsed.Valid = true
+	return parsed, nil
+}
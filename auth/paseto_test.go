@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPAE(t *testing.T) {
+	// Examples from the PASETO specification
+	assert.Equal(t, []byte("\x00\x00\x00\x00\x00\x00\x00\x00"), pae())
+	assert.Equal(t, []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), pae([]byte{}))
+	assert.Equal(t, []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00test"), pae([]byte("test")))
+}
+
+func TestPasetoV4Public(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	token, err := PasetoV4Sign(priv, []byte(`{"data":"this is a signed message"}`), []byte(`{"kid":"1"}`), []byte("implicit"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "v4.public."))
+
+	message, footer, err := PasetoV4Verify(pub, token, []byte("implicit"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":"this is a signed message"}`, string(message))
+	assert.Equal(t, `{"kid":"1"}`, string(footer))
+
+	t.Run("WrongImplicit", func(t *testing.T) {
+		_, _, err := PasetoV4Verify(pub, token, nil)
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		_, _, err = PasetoV4Verify(other, token, []byte("implicit"))
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("WrongHeader", func(t *testing.T) {
+		_, _, err := PasetoV4Verify(pub, strings.Replace(token, "v4.public.", "v3.public.", 1), []byte("implicit"))
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, _, err := PasetoV4Verify(pub, "v4.public.AAAA", nil)
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("InvalidKeySize", func(t *testing.T) {
+		_, _, err := PasetoV4Verify(pub[:16], token, []byte("implicit"))
+		require.Error(t, err)
+
+		_, err = ParsePaseto(token, PasetoOptions{PublicKey: ed25519.PublicKey("short")})
+		require.Error(t, err)
+
+		_, err = PasetoV4Sign(priv[:32], []byte("m"), nil, nil)
+		require.Error(t, err)
+
+		_, err = NewPasetoV4PublicWithClaims(jwt.MapClaims{}, ed25519.PrivateKey("short"))
+		require.Error(t, err)
+	})
+}
+
+func TestPasetoV4Public_Vectors(t *testing.T) {
+	// The v4.public vectors of the PASETO specification (paseto-standard/test-vectors, v4.json)
+	secretKey, err := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	require.NoError(t, err)
+	priv := ed25519.PrivateKey(secretKey)
+
+	for _, v := range []struct {
+		name, token, payload, footer, implicit string
+	}{
+		{
+			name:     "4-S-1",
+			token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+			payload:  `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "",
+			implicit: "",
+		},
+		{
+			name:     "4-S-2",
+			token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: "",
+		},
+		{
+			name:     "4-S-3",
+			token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: `{"test-vector":"4-S-3"}`,
+		},
+	} {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			// Ed25519 signatures are deterministic, so signing reproduces the token
+			token, err := PasetoV4Sign(priv, []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			require.NoError(t, err)
+			assert.Equal(t, v.token, token)
+
+			message, footer, err := PasetoV4Verify(priv.Public().(ed25519.PublicKey), v.token, []byte(v.implicit))
+			require.NoError(t, err)
+			assert.Equal(t, v.payload, string(message))
+			assert.Equal(t, v.footer, string(footer))
+		})
+	}
+}
+
+func TestPasetoV4Local(t *testing.T) {
+	key := make([]byte, PasetoLocalKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	token, err := PasetoV4Encrypt(key, []byte("secret message"), []byte("footer"), nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "v4.local."))
+	assert.NotContains(t, token, "secret")
+
+	message, footer, err := PasetoV4Decrypt(key, token, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret message", string(message))
+	assert.Equal(t, "footer", string(footer))
+
+	t.Run("Tampered", func(t *testing.T) {
+		body, footer, err := pasetoDecode(PasetoV4Local, token)
+		require.NoError(t, err)
+		body[40] ^= 1
+
+		_, _, err = PasetoV4Decrypt(key, pasetoEncode(PasetoV4Local, body, footer), nil)
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		_, _, err := PasetoV4Decrypt(make([]byte, PasetoLocalKeySize), token, nil)
+		assert.Equal(t, ErrPasetoInvalid, err)
+	})
+
+	t.Run("InvalidKeySize", func(t *testing.T) {
+		_, err := PasetoV4Encrypt([]byte("short"), []byte("m"), nil, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestPasetoV4Local_Vectors(t *testing.T) {
+	// The v4.local vectors of the PASETO specification (paseto-standard/test-vectors, v4.json)
+	key, err := hex.DecodeString("707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f")
+	require.NoError(t, err)
+
+	for _, v := range []struct {
+		name, nonce, token, payload, footer, implicit string
+	}{
+		{
+			name:     "4-E-1",
+			nonce:    "0000000000000000000000000000000000000000000000000000000000000000",
+			token:    "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+			payload:  `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "",
+			implicit: "",
+		},
+		{
+			name:     "4-E-2",
+			nonce:    "0000000000000000000000000000000000000000000000000000000000000000",
+			token:    "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+			payload:  `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "",
+			implicit: "",
+		},
+		{
+			name:     "4-E-3",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+			payload:  `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "",
+			implicit: "",
+		},
+		{
+			name:     "4-E-4",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ",
+			payload:  `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "",
+			implicit: "",
+		},
+		{
+			name:     "4-E-5",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: "",
+		},
+		{
+			name:     "4-E-6",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: "",
+		},
+		{
+			name:     "4-E-7",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: `{"test-vector":"4-E-7"}`,
+		},
+		{
+			name:     "4-E-8",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+			payload:  `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			implicit: `{"test-vector":"4-E-8"}`,
+		},
+		{
+			name:     "4-E-9",
+			nonce:    "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+			token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6tybdlmnMwcDMw0YxA_gFSE_IUWl78aMtOepFYSWYfQA.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24",
+			payload:  `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:   "arbitrary-string-that-isn't-json",
+			implicit: `{"test-vector":"4-E-9"}`,
+		},
+	} {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			nonce, err := hex.DecodeString(v.nonce)
+			require.NoError(t, err)
+
+			token, err := pasetoV4Encrypt(key, nonce, []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			require.NoError(t, err)
+			assert.Equal(t, v.token, token)
+
+			message, footer, err := PasetoV4Decrypt(key, v.token, []byte(v.implicit))
+			require.NoError(t, err)
+			assert.Equal(t, v.payload, string(message))
+			assert.Equal(t, v.footer, string(footer))
+		})
+	}
+}
+
+func TestParsePaseto(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	opts := PasetoOptions{PublicKey: pub}
+
+	t.Run("Claims", func(t *testing.T) {
+		exp := time.Now().Add(time.Hour).Unix()
+		token, err := NewPasetoV4PublicWithClaims(jwt.MapClaims{"sub": "user-1", "exp": exp}, priv)
+		require.NoError(t, err)
+
+		message, _, err := PasetoV4Verify(pub, token, nil)
+		require.NoError(t, err)
+		assert.Contains(t, string(message), time.Unix(exp, 0).UTC().Format(time.RFC3339), "dates are RFC 3339 strings in the payload")
+
+		parsed, err := ParsePaseto(token, opts)
+		require.NoError(t, err)
+		assert.True(t, parsed.Valid)
+		assert.Equal(t, PasetoV4Public, parsed.Header["alg"])
+
+		claims := parsed.Claims.(jwt.MapClaims)
+		assert.Equal(t, "user-1", claims["sub"])
+		assert.Equal(t, float64(exp), claims["exp"])
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, err := NewPasetoV4PublicWithClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, priv)
+		require.NoError(t, err)
+
+		_, err = ParsePaseto(token, opts)
+		require.Error(t, err)
+	})
+
+	t.Run("LocalNotAccepted", func(t *testing.T) {
+		token, err := NewPasetoV4LocalWithClaims(jwt.MapClaims{}, make([]byte, PasetoLocalKeySize))
+		require.NoError(t, err)
+
+		_, err = ParsePaseto(token, opts)
+		require.Error(t, err)
+	})
+}
+
+func TestJWTMiddleware_Paseto(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	localKey := make([]byte, PasetoLocalKeySize)
+	_, err = rand.Read(localKey)
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		Paseto: &PasetoOptions{PublicKey: pub, LocalKey: localKey},
+	})
+
+	var sub interface{}
+	handler := m.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub = JWTFromContext(r.Context()).Claims.(jwt.MapClaims)["sub"]
+	}))
+
+	request := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Public", func(t *testing.T) {
+		token, err := NewPasetoV4PublicWithClaims(jwt.MapClaims{"sub": "public-user"}, priv)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, request(token))
+		assert.Equal(t, "public-user", sub)
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		token, err := NewPasetoV4LocalWithClaims(jwt.MapClaims{"sub": "local-user"}, localKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, request(token))
+		assert.Equal(t, "local-user", sub)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		token, err := NewPasetoV4PublicWithClaims(jwt.MapClaims{"sub": "public-user"}, other)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, request(token))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, err := NewPasetoV4LocalWithClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, localKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, request(token))
+	})
+
+	t.Run("JWTNotAccepted", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "jwt-user"}).SignedString(localKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, request(token))
+	})
+
+	t.Run("KeyGetterWithoutSigningMethod", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewJWTMiddleware(JWTOptions{
+				Paseto: &PasetoOptions{PublicKey: pub},
+				ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+					return localKey, nil
+				},
+			})
+		})
+	})
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// ErrRouteUnmatched is returned by Policy.Check for requests that match no
+// route when PolicyOptions.Unmatched is set, after Unmatched served them.
+var ErrRouteUnmatched = errors.New("no policy route matches the request")
+
+// Access says whether a route needs a token.
+type Access int
+
+const (
+	// AccessRequired rejects requests without a valid token
+	AccessRequired Access = iota
+	// AccessOptional checks a token when one is sent, requests without one are let through
+	AccessOptional
+	// AccessPublic never looks at tokens
+	AccessPublic
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessRequired:
+		return "required"
+	case AccessOptional:
+		return "optional"
+	case AccessPublic:
+		return "public"
+	default:
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+}
+
+// Route is a rule of a Policy.
+type Route struct {
+	// HTTP method the rule applies to, any method when empty
+	Method string
+	// Path pattern. Patterns ending in a slash match every path below them like
+	// http.ServeMux subtrees, others are matched with path.Match so a * stands
+	// for a single path segment
+	Pattern string
+	Access  Access
+	// Scopes the token must have, see TokenScopes. Ignored for public routes
+	Scopes []string
+}
+
+// cleanPath returns the canonical form of urlPath like http.ServeMux does,
+// so that dot segments and repeated slashes can't slip past a rule.
+func cleanPath(urlPath string) string {
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	cleaned := path.Clean(urlPath)
+	if strings.HasSuffix(urlPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (route Route) matches(method, urlPath string) bool {
+	if route.Method != "" && !strings.EqualFold(route.Method, method) {
+		return false
+	}
+
+	urlPath = cleanPath(urlPath)
+
+	if strings.HasSuffix(route.Pattern, "/") {
+		return strings.HasPrefix(urlPath, route.Pattern)
+	}
+
+	ok, err := path.Match(route.Pattern, urlPath)
+	return err == nil && ok
+}
+
+type PolicyOptions struct {
+	// Middleware the tokens are checked with, its CredentialsOptional setting is
+	// overridden by the access of each route
+	Middleware *JWTMiddleware
+	// Rules checked in order, the first match decides
+	Routes []Route
+	// Serves requests that match no route. When not set they need a valid token,
+	// so forgetting a rule never makes a route public
+	Unmatched http.Handler
+}
+
+// Policy applies a JWTMiddleware per route, so a single handler tree can mix
+// public, optionally and strictly authenticated endpoints.
+type Policy struct {
+	Options PolicyOptions
+
+	required *JWTMiddleware
+	optional *JWTMiddleware
+}
+
+func NewPolicy(options PolicyOptions) *Policy {
+	if options.Middleware == nil {
+		panic("middleware must be set")
+	}
+
+	for _, route := range options.Routes {
+		if route.Pattern == "" {
+			panic("route pattern must be set")
+		}
+
+		if _, err := path.Match(route.Pattern, ""); err != nil {
+			panic(fmt.Sprintf("invalid route pattern %q: %s", route.Pattern, err))
+		}
+	}
+
+	required := *options.Middleware
+	required.Options.CredentialsOptional = false
+
+	optional := *options.Middleware
+	optional.Options.CredentialsOptional = true
+
+	return &Policy{Options: options, required: &required, optional: &optional}
+}
+
+// Match returns the first route matching method and urlPath.
+func (p *Policy) Match(method, urlPath string) (Route, bool) {
+	for _, route := range p.Options.Routes {
+		if route.matches(method, urlPath) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// Uncovered returns the "METHOD /path" endpoints that match no route, meant for
+// a startup or test check that every endpoint of a mux has a rule.
+func (p *Policy) Uncovered(endpoints ...string) []string {
+	var uncovered []string
+	for _, endpoint := range endpoints {
+		parts := strings.Fields(endpoint)
+		method, urlPath := "", endpoint
+		if len(parts) == 2 {
+			method, urlPath = parts[0], parts[1]
+		}
+
+		if _, ok := p.Match(method, urlPath); !ok {
+			uncovered = append(uncovered, endpoint)
+		}
+	}
+	return uncovered
+}
+
+func (p *Policy) Handler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := p.Check(w, r); err != nil {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check authenticates r according to the route it matches. Like CheckJWT it
+// writes the error response itself and returns a non-nil error when the
+// request must not be handled. Requests matching no route are served by
+// Unmatched, if set, and ErrRouteUnmatched is returned.
+func (p *Policy) Check(w http.ResponseWriter, r *http.Request) error {
+	route, ok := p.Match(r.Method, r.URL.Path)
+	if !ok {
+		audit(r.Context(), p.Options.Middleware.Options.Logger, slog.LevelWarn, AuditRouteUnmatched,
+			slog.String("remote_ip", p.Options.Middleware.clientIP(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		)
+
+		if p.Options.Unmatched != nil {
+			p.Options.Unmatched.ServeHTTP(w, r)
+			return ErrRouteUnmatched
+		}
+		route = Route{Access: AccessRequired}
+	}
+
+	switch route.Access {
+	case AccessPublic:
+		return nil
+	case AccessOptional:
+		if err := p.optional.CheckJWT(w, r); err != nil {
+			return err
+		}
+	default:
+		if err := p.required.CheckJWT(w, r); err != nil {
+			return err
+		}
+	}
+
+	token := JWTFromContext(r.Context())
+	if token == nil || len(route.Scopes) == 0 {
+		// No token on an optional route, or an OPTIONS request CheckJWT let through
+		return nil
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if !HasScopes(TokenScopes(claims), route.Scopes...) {
+		return p.insufficientScope(w, r, claims, route.Scopes)
+	}
+	return nil
+}
+
+// insufficientScope rejects a valid token that lacks the scopes of a route (RFC 6750 section 3.1).
+func (p *Policy) insufficientScope(w http.ResponseWriter, r *http.Request, claims jwt.MapClaims, scopes []string) error {
+	scope := strings.Join(scopes, " ")
+	attrs := []slog.Attr{
+		slog.String("remote_ip", p.Options.Middleware.clientIP(r)),
+		slog.String("reason", "insufficient scope"),
+		slog.String("required_scope", scope),
+	}
+	attrs = append(attrs, tokenAttrs(claims)...)
+	audit(r.Context(), p.Options.Middleware.Options.Logger, slog.LevelWarn, AuditAuthFailed, attrs...)
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+	http.Error(w, "The token does not grant the required scope", http.StatusForbidden)
+	return errors.New("insufficient scope")
+}
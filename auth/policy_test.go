@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestRoute_matches(t *testing.T) {
+	assert.True(t, Route{Pattern: "/healthz"}.matches("GET", "/healthz"))
+	assert.False(t, Route{Pattern: "/healthz"}.matches("GET", "/healthz/deep"))
+	assert.True(t, Route{Pattern: "/assets/"}.matches("GET", "/assets/css/site.css"))
+	assert.True(t, Route{Pattern: "/users/*"}.matches("GET", "/users/42"))
+	assert.False(t, Route{Pattern: "/users/*"}.matches("GET", "/users/42/posts"))
+	assert.True(t, Route{Method: "post", Pattern: "/users"}.matches("POST", "/users"))
+	assert.False(t, Route{Method: "POST", Pattern: "/users"}.matches("GET", "/users"))
+
+	// Paths are cleaned before matching
+	assert.False(t, Route{Pattern: "/assets/"}.matches("GET", "/assets/../api/keys"))
+	assert.True(t, Route{Pattern: "/api/"}.matches("GET", "/assets/../api/keys"))
+	assert.True(t, Route{Pattern: "/admin"}.matches("GET", "//admin"))
+	assert.True(t, Route{Pattern: "/admin"}.matches("GET", "/./admin"))
+	assert.True(t, Route{Pattern: "/users/*"}.matches("GET", "/users//42"))
+	assert.True(t, Route{Pattern: "/assets/"}.matches("GET", "/assets/css/./"))
+}
+
+func TestPolicy(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+	})
+
+	policy := NewPolicy(PolicyOptions{
+		Middleware: m,
+		Routes: []Route{
+			{Pattern: "/healthz", Access: AccessPublic},
+			{Pattern: "/assets/", Access: AccessPublic},
+			{Method: "GET", Pattern: "/articles/*", Access: AccessOptional},
+			{Method: "DELETE", Pattern: "/articles/*", Access: AccessRequired, Scopes: []string{"articles:delete"}},
+			{Pattern: "/api/", Access: AccessRequired},
+		},
+	})
+
+	handler := policy.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if JWTFromContext(r.Context()) != nil {
+			w.Header().Set("X-Authenticated", "true")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(method, target string, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "https://example.com"+target, nil)
+		if claims != nil {
+			token, err := NewJWTWithClaims(claims, key)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "bearer "+token)
+		}
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Public", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, request("GET", "/healthz", nil).Code)
+		assert.Equal(t, http.StatusNoContent, request("GET", "/assets/app.js", nil).Code)
+	})
+
+	t.Run("Optional", func(t *testing.T) {
+		t.Run("Anonymous", func(t *testing.T) {
+			w := request("GET", "/articles/1", nil)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Empty(t, w.Header().Get("X-Authenticated"))
+		})
+
+		t.Run("Authenticated", func(t *testing.T) {
+			w := request("GET", "/articles/1", jwt.MapClaims{"sub": "alice"})
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Equal(t, "true", w.Header().Get("X-Authenticated"))
+		})
+
+		t.Run("InvalidToken", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://example.com/articles/1", nil)
+			req.Header.Set("Authorization", "bearer 123")
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	})
+
+	t.Run("Required", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", nil).Code)
+		assert.Equal(t, http.StatusNoContent, request("GET", "/api/me", jwt.MapClaims{"sub": "alice"}).Code)
+
+		// A public prefix doesn't open up what a dot segment leads out of it
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/assets/../api/me", nil).Code)
+	})
+
+	t.Run("Scopes", func(t *testing.T) {
+		w := request("DELETE", "/articles/1", jwt.MapClaims{"scope": "articles:read"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+
+		assert.Equal(t, http.StatusNoContent, request("DELETE", "/articles/1", jwt.MapClaims{"scope": "articles:read articles:delete"}).Code)
+	})
+
+	t.Run("MiddlewareCredentialsOptional", func(t *testing.T) {
+		m.Options.CredentialsOptional = true
+		defer func() { m.Options.CredentialsOptional = false }()
+
+		strict := NewPolicy(PolicyOptions{Middleware: m, Routes: []Route{{Pattern: "/api/"}}})
+		w := httptest.NewRecorder()
+		require.Error(t, strict.Check(w, httptest.NewRequest("GET", "https://example.com/api/me", nil)))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Unmatched", func(t *testing.T) {
+		t.Run("FailClosed", func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin", nil).Code)
+			assert.Equal(t, http.StatusNoContent, request("GET", "/admin", jwt.MapClaims{"sub": "alice"}).Code)
+		})
+
+		t.Run("Handler", func(t *testing.T) {
+			withHandler := NewPolicy(PolicyOptions{Middleware: m, Unmatched: http.NotFoundHandler()})
+			w := httptest.NewRecorder()
+			withHandler.Handler()(handler).ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/admin", nil))
+			assert.Equal(t, http.StatusNotFound, w.Code)
+
+			// Check on its own doesn't let the request through either
+			w = httptest.NewRecorder()
+			assert.Equal(t, ErrRouteUnmatched, withHandler.Check(w, httptest.NewRequest("GET", "https://example.com/admin", nil)))
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+
+		t.Run("Uncovered", func(t *testing.T) {
+			assert.Equal(t, []string{"POST /admin", "/metrics"}, policy.Uncovered("GET /healthz", "POST /admin", "DELETE /articles/2", "/metrics"))
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Common purposes for PurposeTokens.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+// purposeTokenType is the typ header of purpose tokens, it keeps them from
+// being accepted as access tokens.
+const purposeTokenType = "purpose+jwt"
+
+var (
+	ErrPurposeTokenInvalid = errors.New("purpose token is not valid")
+	ErrPurposeTokenExpired = errors.New("purpose token has expired")
+	// Returned when the state the token was issued for, like the password hash, has changed since
+	ErrPurposeTokenStale = errors.New("purpose token is no longer valid")
+)
+
+type PurposeTokenOptions struct {
+	// HMAC secret, at least 32 bytes. Keep it separate from other secrets
+	Secret []byte
+	// Clock used for expiry, defaults to time.Now
+	Now func() time.Time
+}
+
+// PurposeTokens issues HMAC signed tokens that are only valid for a single
+// purpose (password reset, email verification, ...) and subject. A token also
+// carries a fingerprint of some state of the subject, usually the current
+// password hash, so that it stops working as soon as that state changes.
+type PurposeTokens struct {
+	Options PurposeTokenOptions
+}
+
+func NewPurposeTokens(options PurposeTokenOptions) *PurposeTokens {
+	if len(options.Secret) < 32 {
+		panic("secret must be at least 32 bytes")
+	}
+
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return &PurposeTokens{options}
+}
+
+// key derives the signing key for purpose, so tokens for one purpose can't be
+// verified for another even if the claims were to match.
+func (p *PurposeTokens) key(purpose string) []byte {
+	mac := hmac.New(sha256.New, p.Options.Secret)
+	mac.Write([]byte("purpose-token:" + purpose))
+	return mac.Sum(nil)
+}
+
+func (p *PurposeTokens) fingerprint(purpose string, state []byte) string {
+	mac := hmac.New(sha256.New, p.key(purpose))
+	mac.Write([]byte("fingerprint:"))
+	mac.Write(state)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Generate returns a token for purpose and subject that expires after ttl.
+// state is fingerprinted into the token, e.g. the current password hash for a
+// password reset or the email address for an email verification.
+func (p *PurposeTokens) Generate(purpose, subject string, state []byte, ttl time.Duration) (string, error) {
+	if purpose == "" || subject == "" {
+		return "", errors.New("purpose and subject are required")
+	}
+
+	now := p.Options.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"pur": purpose,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"fpt": p.fingerprint(purpose, state),
+	})
+	token.Header["typ"] = purposeTokenType
+	return token.SignedString(p.key(purpose))
+}
+
+// Verify checks a token for purpose and returns its subject. currentState is
+// called with the subject and must return the same kind of state that was passed
+// to Generate, as it is now.
+func (p *PurposeTokens) Verify(token, purpose string, currentState func(subject string) ([]byte, error)) (string, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	parsed, err := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		if token.Header["typ"] != purposeTokenType {
+			return nil, errors.New("not a purpose token")
+		}
+		return p.key(purpose), nil
+	})
+	if err != nil {
+		return "", ErrPurposeTokenInvalid
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	subject, _ := claims["sub"].(string)
+	fingerprint, _ := claims["fpt"].(string)
+	if claims["pur"] != purpose || subject == "" {
+		return "", ErrPurposeTokenInvalid
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || !p.Options.Now().Before(time.Unix(int64(exp), 0)) {
+		return "", ErrPurposeTokenExpired
+	}
+
+	state, err := currentState(subject)
+	if err != nil {
+		return "", err
+	}
+
+	if !hmac.Equal([]byte(fingerprint), []byte(p.fingerprint(purpose, state))) {
+		return "", ErrPurposeTokenStale
+	}
+	return subject, nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurposeTokens(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	tokens := NewPurposeTokens(PurposeTokenOptions{
+		Secret: []byte("0123456789abcdef0123456789abcdef"),
+		Now:    clock.Now,
+	})
+
+	passwordHashes := map[string][]byte{"user-1": []byte("$2a$10$old")}
+	currentHash := func(subject string) ([]byte, error) {
+		hash, ok := passwordHashes[subject]
+		if !ok {
+			return nil, errors.New("unknown user")
+		}
+		return hash, nil
+	}
+
+	token, err := tokens.Generate(PurposePasswordReset, "user-1", passwordHashes["user-1"], time.Hour)
+	require.NoError(t, err)
+
+	t.Run("Valid", func(t *testing.T) {
+		subject, err := tokens.Verify(token, PurposePasswordReset, currentHash)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", subject)
+	})
+
+	t.Run("WrongPurpose", func(t *testing.T) {
+		_, err := tokens.Verify(token, PurposeEmailVerification, currentHash)
+		assert.Equal(t, ErrPurposeTokenInvalid, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		_, err := tokens.Verify(token+"x", PurposePasswordReset, currentHash)
+		assert.Equal(t, ErrPurposeTokenInvalid, err)
+	})
+
+	t.Run("OtherSecret", func(t *testing.T) {
+		other := NewPurposeTokens(PurposeTokenOptions{Secret: []byte("fedcba9876543210fedcba9876543210")})
+		_, err := other.Verify(token, PurposePasswordReset, currentHash)
+		assert.Equal(t, ErrPurposeTokenInvalid, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clock.Advance(2 * time.Hour)
+		defer clock.Advance(-2 * time.Hour)
+
+		_, err := tokens.Verify(token, PurposePasswordReset, currentHash)
+		assert.Equal(t, ErrPurposeTokenExpired, err)
+	})
+
+	t.Run("UnknownSubject", func(t *testing.T) {
+		orphan, err := tokens.Generate(PurposePasswordReset, "user-2", nil, time.Hour)
+		require.NoError(t, err)
+
+		_, err = tokens.Verify(orphan, PurposePasswordReset, currentHash)
+		assert.EqualError(t, err, "unknown user")
+	})
+
+	t.Run("PasswordChanged", func(t *testing.T) {
+		passwordHashes["user-1"] = []byte("$2a$10$new")
+		_, err := tokens.Verify(token, PurposePasswordReset, currentHash)
+		assert.Equal(t, ErrPurposeTokenStale, err)
+	})
+
+	t.Run("NotAnAccessToken", func(t *testing.T) {
+		m := NewJWTMiddleware(JWTOptions{
+			SigningMethod: jwt.SigningMethodHS256,
+			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+				return tokens.key(PurposePasswordReset), nil
+			},
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		require.Error(t, m.CheckJWT(w, req))
+	})
+}
+
+func TestNewPurposeTokens(t *testing.T) {
+	assert.Panics(t, func() {
+		NewPurposeTokens(PurposeTokenOptions{Secret: []byte("short")})
+	})
+}
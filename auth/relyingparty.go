@@ -0,0 +1,542 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// loginStateType is the typ header of the login state cookie.
+const loginStateType = "oidc-state+jwt"
+
+var ErrLoginStateInvalid = errors.New("oidc login state is missing, expired or does not match")
+
+// defaultHTTPClient is used to call providers when no client is given, so
+// that a stalled provider can't hold up requests forever.
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// DiscoverOIDC fetches the metadata of the provider at issuer.
+func DiscoverOIDC(ctx context.Context, issuer string, client *http.Client) (*OIDCDiscovery, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+OIDCDiscoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed with status %d", resp.StatusCode)
+	}
+
+	var discovery OIDCDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, errors.Wrap(err, "invalid oidc discovery document")
+	}
+
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("oidc discovery document is for issuer %q, expected %q", discovery.Issuer, issuer)
+	}
+	return &discovery, nil
+}
+
+// jwksRefetchInterval is how long NewJWKSKeyGetter waits before fetching the
+// set again for an unknown key.
+const jwksRefetchInterval = time.Minute
+
+// NewJWKSKeyGetter returns a jwt.Keyfunc that picks the key named by the kid
+// header from the JWK set at jwksURL. The set is fetched again when a token
+// names an unknown key, at most once a minute. client defaults to one with a
+// ten second timeout.
+func NewJWKSKeyGetter(jwksURL string, client *http.Client) jwt.Keyfunc {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	var mu sync.Mutex
+	var keys map[string]interface{}
+	var fetchedAt time.Time
+	// Closed once the fetch in progress, if any, is done
+	var fetching chan struct{}
+
+	fetch := func() (map[string]interface{}, error) {
+		resp, err := client.Get(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching jwks failed with status %d", resp.StatusCode)
+		}
+
+		var jwks JWKS
+		if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+			return nil, errors.Wrap(err, "invalid jwks")
+		}
+
+		fetched := map[string]interface{}{}
+		for _, jwk := range jwks.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+
+			pub, err := jwk.PublicKey()
+			if err != nil {
+				continue
+			}
+			fetched[jwk.Kid] = pub
+		}
+		return fetched, nil
+	}
+
+	lookup := func(kid string) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		mu.Lock()
+		if key, ok := keys[kid]; ok {
+			mu.Unlock()
+			return key, nil
+		}
+
+		// Wait for a fetch that is already in progress instead of starting another
+		if wait := fetching; wait != nil {
+			mu.Unlock()
+			<-wait
+			return lookup(kid)
+		}
+
+		if time.Since(fetchedAt) < jwksRefetchInterval {
+			mu.Unlock()
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+
+		fetchedAt = time.Now()
+		done := make(chan struct{})
+		fetching = done
+		mu.Unlock()
+
+		// Other keys stay usable while the set is fetched
+		fetched, err := fetch()
+
+		mu.Lock()
+		if err == nil {
+			keys = fetched
+		}
+		fetching = nil
+		close(done)
+		mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return lookup(kid)
+	}
+}
+
+type RelyingPartyOptions struct {
+	// Expected iss of ID tokens
+	Issuer   string
+	ClientID string
+	// Leave empty for public clients
+	ClientSecret string
+	// Callback URL registered with the provider
+	RedirectURL string
+	// Endpoints of the provider, see DiscoverOIDC
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	// Scopes to request, defaults to openid. openid is always requested
+	Scopes []string
+	// Returns the key ID tokens are verified with, see NewJWKSKeyGetter
+	ValidationKeyGetter jwt.Keyfunc
+	// Signing method ID tokens must use, defaults to RS256
+	SigningMethod jwt.SigningMethod
+	// Secret the login state cookie is signed with, at least 32 bytes
+	CookieKey []byte
+	// Name of the login state cookie, defaults to "oidc_login"
+	CookieName string
+	// How long users have to log in at the provider, defaults to ten minutes
+	CookieTTL time.Duration
+	// When set, the cookie is also sent over plain HTTP. Only for local development
+	InsecureCookie bool
+	// Used to call the token endpoint, defaults to a client with a ten second timeout
+	HTTPClient *http.Client
+}
+
+// RelyingParty logs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. The state, nonce and code verifier of a
+// login are kept in a signed cookie between Login and Callback.
+type RelyingParty struct {
+	Options RelyingPartyOptions
+}
+
+func NewRelyingParty(options RelyingPartyOptions) *RelyingParty {
+	if options.Issuer == "" {
+		panic("issuer must be set")
+	}
+
+	if options.ClientID == "" {
+		panic("client id must be set")
+	}
+
+	if options.RedirectURL == "" {
+		panic("redirect url must be set")
+	}
+
+	if options.AuthorizationEndpoint == "" || options.TokenEndpoint == "" {
+		panic("authorization and token endpoints must be set")
+	}
+
+	if options.ValidationKeyGetter == nil {
+		panic("validation key getter must be set")
+	}
+
+	if len(options.CookieKey) < 32 {
+		panic("cookie key must be at least 32 bytes")
+	}
+
+	if !HasScopes(options.Scopes, "openid") {
+		options.Scopes = append([]string{"openid"}, options.Scopes...)
+	}
+
+	if options.SigningMethod == nil {
+		options.SigningMethod = jwt.SigningMethodRS256
+	}
+
+	if options.CookieName == "" {
+		options.CookieName = "oidc_login"
+	}
+
+	if options.CookieTTL <= 0 {
+		options.CookieTTL = 10 * time.Minute
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = defaultHTTPClient
+	}
+
+	return &RelyingParty{options}
+}
+
+func (p *RelyingParty) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.Options.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   !p.Options.InsecureCookie,
+		HttpOnly: true,
+		// Lax, the provider redirects back with a top-level navigation
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeReturnTo only allows local paths, so that the login can't be used as an open redirect.
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// AuthCodeURL starts a login: it sets the state cookie on w and returns the
+// provider URL to send the user to. returnTo is the local path the user is
+// sent back to after Callback.
+func (p *RelyingParty) AuthCodeURL(w http.ResponseWriter, returnTo string) (string, error) {
+	state, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	cookie := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"state":     state,
+		"nonce":     nonce,
+		"verifier":  verifier,
+		"return_to": safeReturnTo(returnTo),
+		"exp":       time.Now().Add(p.Options.CookieTTL).Unix(),
+	})
+	cookie.Header["typ"] = loginStateType
+
+	signed, err := cookie.SignedString(p.Options.CookieKey)
+	if err != nil {
+		return "", err
+	}
+	p.setCookie(w, signed, int(p.Options.CookieTTL.Seconds()))
+
+	return appendQuery(p.Options.AuthorizationEndpoint, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Options.ClientID},
+		"redirect_uri":          {p.Options.RedirectURL},
+		"scope":                 {strings.Join(p.Options.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}), nil
+}
+
+// Login redirects to the provider, the return_to query parameter names the
+// local path to come back to.
+func (p *RelyingParty) Login(w http.ResponseWriter, r *http.Request) {
+	u, err := p.AuthCodeURL(w, r.URL.Query().Get("return_to"))
+	if err != nil {
+		http.Error(w, "Unable to start login", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// IDTokenClaims are the validated claims of an ID token.
+type IDTokenClaims struct {
+	Issuer            string
+	Subject           string
+	Audience          []string
+	ExpiresAt         time.Time
+	IssuedAt          time.Time
+	AuthTime          time.Time
+	Nonce             string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	// All claims of the token, including the ones above
+	Claims jwt.MapClaims
+}
+
+func claimTime(claims jwt.MapClaims, name string) time.Time {
+	if value, ok := claims[name].(float64); ok {
+		return time.Unix(int64(value), 0)
+	}
+	return time.Time{}
+}
+
+func newIDTokenClaims(claims jwt.MapClaims) *IDTokenClaims {
+	id := &IDTokenClaims{
+		Audience:  tokenAudience(claims),
+		ExpiresAt: claimTime(claims, "exp"),
+		IssuedAt:  claimTime(claims, "iat"),
+		AuthTime:  claimTime(claims, "auth_time"),
+		Claims:    claims,
+	}
+	id.Issuer, _ = claims["iss"].(string)
+	id.Subject, _ = claims["sub"].(string)
+	id.Nonce, _ = claims["nonce"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Name, _ = claims["name"].(string)
+	id.PreferredUsername, _ = claims["preferred_username"].(string)
+	return id
+}
+
+// OIDCLogin is the result of a successful Callback.
+type OIDCLogin struct {
+	IDToken *IDTokenClaims
+	// Tokens returned by the provider
+	Token *TokenResponse
+	// Local path passed to AuthCodeURL
+	ReturnTo string
+}
+
+// Callback completes a login on the redirect URL: it checks the state cookie,
+// exchanges the code and validates the ID token. Errors returned by the
+// provider are returned as *OAuthError.
+func (p *RelyingParty) Callback(w http.ResponseWriter, r *http.Request) (*OIDCLogin, error) {
+	cookie, err := r.Cookie(p.Options.CookieName)
+	if err != nil {
+		return nil, ErrLoginStateInvalid
+	}
+	// A login state is only ever used once
+	p.setCookie(w, "", -1)
+
+	parsed, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 || token.Header["typ"] != loginStateType {
+			return nil, errors.New("not a login state")
+		}
+		return p.Options.CookieKey, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrLoginStateInvalid
+	}
+
+	state := parsed.Claims.(jwt.MapClaims)
+	expected, _ := state["state"].(string)
+	query := r.URL.Query()
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(query.Get("state"))) != 1 {
+		return nil, ErrLoginStateInvalid
+	}
+
+	// RFC 9207, protects against mix-up attacks when several providers are used
+	if iss := query.Get("iss"); iss != "" && iss != p.Options.Issuer {
+		return nil, fmt.Errorf("authorization response is from issuer %q, expected %q", iss, p.Options.Issuer)
+	}
+
+	if code := query.Get("error"); code != "" {
+		return nil, &OAuthError{Code: code, Description: query.Get("error_description")}
+	}
+
+	verifier, _ := state["verifier"].(string)
+	token, err := p.exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, _ := state["nonce"].(string)
+	idToken, err := p.ValidateIDToken(token.IDToken, nonce, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	returnTo, _ := state["return_to"].(string)
+	return &OIDCLogin{IDToken: idToken, Token: token, ReturnTo: safeReturnTo(returnTo)}, nil
+}
+
+// exchange redeems an authorization code at the token endpoint.
+func (p *RelyingParty) exchange(ctx context.Context, code, verifier string) (*TokenResponse, error) {
+	if code == "" {
+		return nil, &OAuthError{Code: "invalid_request", Description: "the authorization response has no code"}
+	}
+
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {p.Options.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.Options.ClientSecret == "" {
+		form.Set("client_id", p.Options.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Options.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Options.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Options.ClientID), url.QueryEscape(p.Options.ClientSecret))
+	}
+
+	resp, err := p.Options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &OAuthError{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(oauthErr); err != nil || oauthErr.Code == "" {
+			return nil, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+		}
+		return nil, oauthErr
+	}
+
+	var token TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, errors.Wrap(err, "invalid token response")
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return &token, nil
+}
+
+// idTokenHash returns the hash function at_hash is computed with for alg.
+func idTokenHash(alg string) (func() hash.Hash, error) {
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		return sha256.New, nil
+	case strings.HasSuffix(alg, "384"):
+		return sha512.New384, nil
+	case strings.HasSuffix(alg, "512"):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported id token algorithm %q", alg)
+}
+
+// ValidateIDToken verifies an ID token and its claims (OpenID Connect Core
+// section 3.1.3.7). at_hash is checked against accessToken when both are present.
+func (p *RelyingParty) ValidateIDToken(idToken, nonce, accessToken string) (*IDTokenClaims, error) {
+	parsed, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != p.Options.SigningMethod.Alg() {
+			return nil, fmt.Errorf("expected %s signing method but token specified %v", p.Options.SigningMethod.Alg(), token.Header["alg"])
+		}
+		return p.Options.ValidationKeyGetter(token)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid id token")
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	id := newIDTokenClaims(claims)
+	switch {
+	case id.Issuer != p.Options.Issuer:
+		return nil, errors.New("invalid id token: unexpected issuer")
+	case !containsAll(id.Audience, []string{p.Options.ClientID}):
+		return nil, errors.New("invalid id token: not issued for this client")
+	case len(id.Audience) > 1 && claims["azp"] != p.Options.ClientID:
+		return nil, errors.New("invalid id token: unexpected authorized party")
+	case id.ExpiresAt.IsZero():
+		return nil, errors.New("invalid id token: missing exp claim")
+	case id.Subject == "":
+		return nil, errors.New("invalid id token: missing sub claim")
+	case subtle.ConstantTimeCompare([]byte(id.Nonce), []byte(nonce)) != 1:
+		return nil, errors.New("invalid id token: nonce does not match")
+	}
+
+	if expected, ok := claims["at_hash"].(string); ok && accessToken != "" {
+		h, err := idTokenHash(parsed.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+
+		sum := h()
+		sum.Write([]byte(accessToken))
+		digest := sum.Sum(nil)
+		if base64.RawURLEncoding.EncodeToString(digest[:len(digest)/2]) != expected {
+			return nil, errors.New("invalid id token: at_hash does not match the access token")
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestSafeReturnTo(t *testing.T) {
+	assert.Equal(t, "/dashboard?tab=1", safeReturnTo("/dashboard?tab=1"))
+	assert.Equal(t, "/", safeReturnTo(""))
+	assert.Equal(t, "/", safeReturnTo("https://evil.example.com"))
+	assert.Equal(t, "/", safeReturnTo("//evil.example.com"))
+	assert.Equal(t, "/", safeReturnTo("/\\evil.example.com"))
+}
+
+func TestRelyingParty(t *testing.T) {
+	provider, server := newTestOIDCProvider(t)
+
+	discovery, err := DiscoverOIDC(context.Background(), server.URL, nil)
+	require.NoError(t, err)
+
+	rp := NewRelyingParty(RelyingPartyOptions{
+		Issuer:                discovery.Issuer,
+		ClientID:              "web",
+		ClientSecret:          "s3cret",
+		RedirectURL:           "https://app.example.com/callback",
+		AuthorizationEndpoint: discovery.AuthorizationEndpoint,
+		TokenEndpoint:         discovery.TokenEndpoint,
+		Scopes:                []string{"email"},
+		ValidationKeyGetter:   NewJWKSKeyGetter(discovery.JWKSURI, nil),
+		CookieKey:             []byte("0123456789abcdef0123456789abcdef"),
+	})
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// login starts a login as alice and returns the state cookie and the callback URL
+	login := func(t *testing.T, returnTo string) (*http.Cookie, *url.URL) {
+		w := httptest.NewRecorder()
+		authURL, err := rp.AuthCodeURL(w, returnTo)
+		require.NoError(t, err)
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+
+		req, err := http.NewRequest("GET", authURL, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-User", "alice")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return cookies[0], callback
+	}
+
+	callback := func(cookie *http.Cookie, callback *url.URL) (*OIDCLogin, *httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest("GET", callback.String(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		result, err := rp.Callback(w, req)
+		return result, w, err
+	}
+
+	t.Run("Login", func(t *testing.T) {
+		cookie, location := login(t, "/dashboard")
+		result, w, err := callback(cookie, location)
+		require.NoError(t, err)
+
+		assert.Equal(t, "/dashboard", result.ReturnTo)
+		assert.Equal(t, "alice", result.IDToken.Subject)
+		assert.Equal(t, server.URL, result.IDToken.Issuer)
+		assert.Equal(t, []string{"web"}, result.IDToken.Audience)
+		assert.Equal(t, "alice@example.com", result.IDToken.Email)
+		assert.True(t, result.IDToken.EmailVerified)
+		assert.Equal(t, testAuthTime, result.IDToken.AuthTime.UTC())
+		assert.NotEmpty(t, result.Token.AccessToken)
+
+		// The state cookie is removed
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.True(t, cookies[0].MaxAge < 0)
+
+		t.Run("Replayed", func(t *testing.T) {
+			_, _, err := callback(cookie, location)
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("MissingCookie", func(t *testing.T) {
+		_, location := login(t, "/")
+		_, _, err := callback(nil, location)
+		assert.Equal(t, ErrLoginStateInvalid, err)
+	})
+
+	t.Run("StateMismatch", func(t *testing.T) {
+		cookie, _ := login(t, "/")
+		_, location := login(t, "/")
+		_, _, err := callback(cookie, location)
+		assert.Equal(t, ErrLoginStateInvalid, err)
+	})
+
+	t.Run("ProviderError", func(t *testing.T) {
+		cookie, location := login(t, "/")
+		query := url.Values{"state": {location.Query().Get("state")}, "error": {"access_denied"}}
+		location.RawQuery = query.Encode()
+
+		_, _, err := callback(cookie, location)
+		require.IsType(t, &OAuthError{}, err)
+		assert.Equal(t, "access_denied", err.(*OAuthError).Code)
+	})
+
+	t.Run("IssuerMixUp", func(t *testing.T) {
+		cookie, location := login(t, "/")
+		query := location.Query()
+		query.Set("iss", "https://other.example.com")
+		location.RawQuery = query.Encode()
+
+		_, _, err := callback(cookie, location)
+		assert.Error(t, err)
+	})
+
+	t.Run("ValidateIDToken", func(t *testing.T) {
+		idToken, err := provider.signIDToken(map[string]interface{}{
+			"iss": server.URL, "sub": "alice", "aud": "web", "exp": 4102444800, "nonce": "abc",
+		})
+		require.NoError(t, err)
+
+		_, err = rp.ValidateIDToken(idToken, "abc", "")
+		assert.NoError(t, err)
+
+		_, err = rp.ValidateIDToken(idToken, "other", "")
+		assert.Error(t, err)
+
+		otherClient := *rp
+		otherClient.Options.ClientID = "spa"
+		_, err = otherClient.ValidateIDToken(idToken, "abc", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestNewJWKSKeyGetter(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+	jwk, err := NewJWK(&key.PublicKey)
+	require.NoError(t, err)
+	jwk.Kid = "key-1"
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		writeJSON(w, http.StatusOK, &JWKS{Keys: []*JWK{jwk}})
+	}))
+	defer server.Close()
+
+	getter := NewJWKSKeyGetter(server.URL, nil)
+	token := func(kid string) *jwt.Token {
+		return &jwt.Token{Header: map[string]interface{}{"kid": kid}}
+	}
+
+	// Concurrent lookups share a single fetch
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := getter(token("key-1"))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	got, err := getter(token("key-1"))
+	require.NoError(t, err)
+	assert.Equal(t, &key.PublicKey, got)
+
+	// Unknown keys don't cause a fetch every time
+	for i := 0; i < 3; i++ {
+		_, err = getter(token("key-2"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	assert.NotZero(t, defaultHTTPClient.Timeout)
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrStreamTokenExpired = errors.New("token of the connection has expired")
+	ErrStreamTokenRevoked = errors.New("token of the connection has been revoked")
+)
+
+// RevocationCheck reports whether a token has been revoked since it was issued.
+type RevocationCheck func(ctx context.Context, token *jwt.Token) (bool, error)
+
+// RefreshMessage is the in-band message clients send over a stream to replace
+// the token of the connection before it expires, e.g.
+// {"type": "auth.refresh", "token": "..."}.
+type RefreshMessage struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// RefreshMessageType is the type of a RefreshMessage.
+const RefreshMessageType = "auth.refresh"
+
+type StreamGuardOptions struct {
+	// Middleware the connection was authenticated with, refreshed tokens are validated with it
+	Middleware *JWTMiddleware
+	// When set, the token of every connection is checked periodically and the connection
+	// is ended once it is revoked
+	Revoked RevocationCheck
+	// How often Revoked is called, defaults to one minute
+	RevocationInterval time.Duration
+}
+
+// StreamGuard keeps long-lived connections (WebSockets, server-sent events) from
+// outliving their token: CheckJWT only validates a token when the connection
+// is opened, the guard ends it when the token expires or is revoked.
+type StreamGuard struct {
+	Options StreamGuardOptions
+}
+
+func NewStreamGuard(options StreamGuardOptions) *StreamGuard {
+	if options.Middleware == nil {
+		panic("middleware must be set")
+	}
+
+	if options.RevocationInterval <= 0 {
+		options.RevocationInterval = time.Minute
+	}
+
+	return &StreamGuard{options}
+}
+
+var streamSessionContextKey = &contextKey{"stream-session"}
+
+// StreamSessionFromContext returns the session started by StreamGuard.Handler.
+func StreamSessionFromContext(ctx context.Context) *StreamSession {
+	if session, ok := ctx.Value(streamSessionContextKey).(*StreamSession); ok {
+		return session
+	}
+	return nil
+}
+
+// Handler watches the token of requests authenticated by the middleware (see
+// JWTFromContext). The request context is cancelled once the token expires or is
+// revoked, handlers of hijacked connections must close them when it is done.
+// Requests without a token are passed through unwatched.
+func (g *StreamGuard) Handler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := JWTFromContext(r.Context())
+			if token == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session := g.Watch(r.Context(), token)
+			defer session.Stop()
+
+			ctx := context.WithValue(session.Context(), streamSessionContextKey, session)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Watch starts a session for a validated token. Stop must be called once the
+// connection is closed.
+func (g *StreamGuard) Watch(ctx context.Context, token *jwt.Token) *StreamSession {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &StreamSession{guard: g, ctx: ctx, cancel: cancel, token: token}
+
+	s.mu.Lock()
+	s.schedule()
+	s.mu.Unlock()
+
+	if g.Options.Revoked != nil {
+		go s.watchRevocation()
+	}
+	return s
+}
+
+// StreamSession is the token state of a single connection.
+type StreamSession struct {
+	guard  *StreamGuard
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	token *jwt.Token
+	timer *time.Timer
+	err   error
+}
+
+// Context is cancelled when the session ends.
+func (s *StreamSession) Context() context.Context {
+	return s.ctx
+}
+
+// Token returns the current token of the connection, which changes with every refresh.
+func (s *StreamSession) Token() *jwt.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Err returns why the session ended: ErrStreamTokenExpired, ErrStreamTokenRevoked or
+// the error of the parent context. It is nil while the session is active.
+func (s *StreamSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.ctx.Err()
+}
+
+// Stop ends the session without an error, it is safe to call more than once.
+func (s *StreamSession) Stop() {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// expiry returns the exp claim of the current token, zero when it has none.
+func (s *StreamSession) expiry() time.Time {
+	claims, _ := s.token.Claims.(jwt.MapClaims)
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(exp), 0)
+	case int64:
+		return time.Unix(exp, 0)
+	case json.Number:
+		if v, err := exp.Int64(); err == nil {
+			return time.Unix(v, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// schedule arms the expiry timer for the current token, s.mu must be held.
+func (s *StreamSession) schedule() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	exp := s.expiry()
+	if exp.IsZero() {
+		s.timer = nil
+		return
+	}
+	s.timer = time.AfterFunc(time.Until(exp), s.checkExpiry)
+}
+
+func (s *StreamSession) checkExpiry() {
+	s.mu.Lock()
+	exp := s.expiry()
+	s.mu.Unlock()
+
+	// A refresh may have happened after the timer fired
+	if !exp.IsZero() && !time.Now().Before(exp) {
+		s.end(ErrStreamTokenExpired)
+	}
+}
+
+func (s *StreamSession) watchRevocation() {
+	ticker := time.NewTicker(s.guard.Options.RevocationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			revoked, err := s.guard.Options.Revoked(s.ctx, s.Token())
+			if err != nil {
+				// Transient failures of the revocation backend don't end connections
+				audit(s.ctx, s.guard.Options.Middleware.Options.Logger, slog.LevelError, AuditStreamEnded,
+					slog.String("reason", "revocation check failed: "+err.Error()))
+				continue
+			}
+
+			if revoked {
+				s.end(ErrStreamTokenRevoked)
+				return
+			}
+		}
+	}
+}
+
+// end cancels the session with err, only the first reason is kept.
+func (s *StreamSession) end(err error) {
+	s.mu.Lock()
+	if s.err != nil || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	claims, _ := s.token.Claims.(jwt.MapClaims)
+	s.mu.Unlock()
+
+	attrs := []slog.Attr{slog.String("reason", err.Error())}
+	attrs = append(attrs, tokenAttrs(claims)...)
+	audit(s.ctx, s.guard.Options.Middleware.Options.Logger, slog.LevelInfo, AuditStreamEnded, attrs...)
+	s.cancel()
+}
+
+// Refresh replaces the token of the connection with raw, extending the session
+// to its expiry. The new token must be valid, for the same subject and bound to
+// the same key as the current one.
+func (s *StreamSession) Refresh(raw string) error {
+	if err := s.Err(); err != nil {
+		return errors.Wrap(err, "session has ended")
+	}
+
+	m := s.guard.Options.Middleware
+	parsed, _, err := m.parse(raw)
+	if err != nil {
+		return err
+	}
+
+	current := s.Token()
+	currentClaims, _ := current.Claims.(jwt.MapClaims)
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	if claims["sub"] != currentClaims["sub"] {
+		return errors.New("refreshed token is for a different subject")
+	}
+
+	if !reflect.DeepEqual(confirmationClaim(claims), confirmationClaim(currentClaims)) {
+		return errors.New("refreshed token is bound to a different key")
+	}
+
+	if s.guard.Options.Revoked != nil {
+		revoked, err := s.guard.Options.Revoked(s.ctx, parsed)
+		if err != nil {
+			return err
+		}
+
+		if revoked {
+			return ErrStreamTokenRevoked
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.ctx.Err() != nil {
+		return errors.New("session has ended")
+	}
+	s.token = parsed
+	s.schedule()
+	return nil
+}
+
+// HandleMessage refreshes the token when data is a RefreshMessage and reports
+// whether it was one. Other messages are left to the caller.
+func (s *StreamSession) HandleMessage(data []byte) (bool, error) {
+	var msg RefreshMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != RefreshMessageType {
+		return false, nil
+	}
+	return true, s.Refresh(msg.Token)
+}
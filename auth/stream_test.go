@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestStreamGuard(t *testing.T) {
+	key, err := crypto.GenerateRsaKey()
+	require.NoError(t, err)
+
+	m := NewJWTMiddleware(JWTOptions{
+		SigningMethod: jwt.SigningMethodRS512,
+		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+	})
+
+	newToken := func(sub string, ttl time.Duration) (string, *jwt.Token) {
+		raw, err := NewJWTWithClaims(jwt.MapClaims{"sub": sub, "exp": time.Now().Add(ttl).Unix()}, key)
+		require.NoError(t, err)
+
+		parsed, _, err := m.parse(raw)
+		require.NoError(t, err)
+		return raw, parsed
+	}
+
+	guard := NewStreamGuard(StreamGuardOptions{Middleware: m})
+
+	t.Run("Expiry", func(t *testing.T) {
+		_, token := newToken("alice", time.Second)
+		session := guard.Watch(context.Background(), token)
+		defer session.Stop()
+
+		assert.NoError(t, session.Err())
+		select {
+		case <-session.Context().Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("session did not end when the token expired")
+		}
+		assert.Equal(t, ErrStreamTokenExpired, session.Err())
+
+		raw, _ := newToken("alice", time.Hour)
+		assert.Error(t, session.Refresh(raw))
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		_, token := newToken("alice", time.Second)
+		session := guard.Watch(context.Background(), token)
+		defer session.Stop()
+
+		raw, _ := newToken("alice", time.Hour)
+		handled, err := session.HandleMessage([]byte(`{"type": "auth.refresh", "token": "` + raw + `"}`))
+		assert.True(t, handled)
+		require.NoError(t, err)
+		assert.Equal(t, raw, session.Token().Raw)
+
+		select {
+		case <-session.Context().Done():
+			t.Fatal("session ended despite the refresh")
+		case <-time.After(2100 * time.Millisecond):
+		}
+		assert.NoError(t, session.Err())
+	})
+
+	t.Run("RefreshRejected", func(t *testing.T) {
+		_, token := newToken("alice", time.Hour)
+		session := guard.Watch(context.Background(), token)
+		defer session.Stop()
+
+		t.Run("OtherSubject", func(t *testing.T) {
+			raw, _ := newToken("mallory", time.Hour)
+			assert.Error(t, session.Refresh(raw))
+		})
+
+		t.Run("Invalid", func(t *testing.T) {
+			assert.Error(t, session.Refresh("123"))
+		})
+
+		t.Run("OtherMessage", func(t *testing.T) {
+			handled, err := session.HandleMessage([]byte(`{"type": "chat", "text": "hi"}`))
+			assert.False(t, handled)
+			assert.NoError(t, err)
+		})
+
+		assert.Equal(t, token, session.Token())
+	})
+
+	t.Run("Revocation", func(t *testing.T) {
+		var revoked int32
+		guard := NewStreamGuard(StreamGuardOptions{
+			Middleware:         m,
+			RevocationInterval: 10 * time.Millisecond,
+			Revoked: func(ctx context.Context, token *jwt.Token) (bool, error) {
+				return atomic.LoadInt32(&revoked) == 1, nil
+			},
+		})
+
+		_, token := newToken("alice", time.Hour)
+		session := guard.Watch(context.Background(), token)
+		defer session.Stop()
+
+		atomic.StoreInt32(&revoked, 1)
+		select {
+		case <-session.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("session did not end when the token was revoked")
+		}
+		assert.Equal(t, ErrStreamTokenRevoked, session.Err())
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		var session *StreamSession
+		handler := m.Handler()(guard.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session = StreamSessionFromContext(r.Context())
+		})))
+
+		raw, _ := newToken("alice", time.Hour)
+		req := httptest.NewRequest("GET", "https://example.com/events", nil)
+		req.Header.Set("Authorization", "bearer "+raw)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.NotNil(t, session)
+		assert.Equal(t, raw, session.Token().Raw)
+		// The session is stopped once the handler returns
+		assert.Equal(t, context.Canceled, session.Err())
+	})
+}
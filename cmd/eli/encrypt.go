@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// secretKeyEnv is read when neither -key nor -key-file is given.
+const secretKeyEnv = "ELI_SECRET_KEY"
+
+type encryptionFlags struct {
+	key     *string
+	keyFile *string
+	in      *string
+	out     *string
+	raw     *bool
+}
+
+func newEncryptionFlags(e *env, name string, args []string, rawUsage string) (*encryptionFlags, error) {
+	fs := newFlagSet(e, name)
+	f := &encryptionFlags{
+		key:     fs.String("key", "", "secret key of 16, 24 or 32 bytes, defaults to $"+secretKeyEnv+". Visible to other users, prefer -key-file"),
+		keyFile: fs.String("key-file", "", "file containing the secret key, - for stdin"),
+		in:      fs.String("in", "", "input file, defaults to stdin"),
+		out:     fs.String("out", "", "output file, defaults to stdout"),
+		raw:     fs.Bool("raw", false, rawUsage),
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	return f, nil
+}
+
+func (f *encryptionFlags) secretKey(e *env) ([]byte, error) {
+	key := *f.key
+	if *f.keyFile != "" {
+		if *f.keyFile == "-" && (*f.in == "" || *f.in == "-") {
+			return nil, errors.New("-key-file - reads the key from stdin, so -in must name a file")
+		}
+
+		raw, err := readInput(e, *f.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = strings.TrimRight(string(raw), "\r\n")
+	}
+
+	if key == "" {
+		key = os.Getenv(secretKeyEnv)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return []byte(key), nil
+	case 0:
+		return nil, fmt.Errorf("a secret key is required, use -key, -key-file or $%s", secretKeyEnv)
+	default:
+		return nil, fmt.Errorf("secret key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+}
+
+func encrypt(e *env, args []string) error {
+	f, err := newEncryptionFlags(e, "encrypt", args, "write raw ciphertext instead of base64")
+	if err != nil {
+		return err
+	}
+
+	key, err := f.secretKey(e)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := readInput(e, *f.in)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := crypto.Encrypt(plaintext, key)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(e, *f.out, 0644, func(w io.Writer) error {
+		if *f.raw {
+			_, err := w.Write(ciphertext)
+			return err
+		}
+		_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(ciphertext))
+		return err
+	})
+}
+
+func decrypt(e *env, args []string) error {
+	f, err := newEncryptionFlags(e, "decrypt", args, "read raw ciphertext instead of base64")
+	if err != nil {
+		return err
+	}
+
+	key, err := f.secretKey(e)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := readInput(e, *f.in)
+	if err != nil {
+		return err
+	}
+
+	if !*f.raw {
+		if ciphertext, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(ciphertext))); err != nil {
+			return errors.Wrap(err, "input is not base64, pass -raw for binary input")
+		}
+	}
+
+	plaintext, err := crypto.Decrypt(ciphertext, key)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(e, *f.out, 0600, func(w io.Writer) error {
+		_, err := w.Write(plaintext)
+		return err
+	})
+}
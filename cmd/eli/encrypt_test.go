@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecretKey = "o4H845smMQNOOXmELqpAClvsW5dDVEJa"
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Run("Base64", func(t *testing.T) {
+		code, ciphertext, _ := runCommand("secret data", "encrypt", "-key", testSecretKey)
+		require.Equal(t, 0, code)
+		assert.NotContains(t, ciphertext, "secret data")
+
+		code, plaintext, _ := runCommand(ciphertext, "decrypt", "-key", testSecretKey)
+		require.Equal(t, 0, code)
+		assert.Equal(t, "secret data", plaintext)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "eli")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		in := filepath.Join(dir, "in.txt")
+		encrypted := filepath.Join(dir, "in.txt.enc")
+		out := filepath.Join(dir, "out.txt")
+		keyFile := filepath.Join(dir, "key")
+		require.NoError(t, ioutil.WriteFile(in, []byte("file data"), 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, []byte(testSecretKey+"\n"), 0600))
+
+		code, _, stderr := runCommand("", "encrypt", "-key-file", keyFile, "-raw", "-in", in, "-out", encrypted)
+		require.Equal(t, 0, code, stderr)
+
+		code, _, stderr = runCommand("", "decrypt", "-key-file", keyFile, "-raw", "-in", encrypted, "-out", out)
+		require.Equal(t, 0, code, stderr)
+
+		data, err := ioutil.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "file data", string(data))
+
+		t.Run("ExistingFile", func(t *testing.T) {
+			// The mode applies to files that already exist too
+			require.NoError(t, os.Chmod(out, 0644))
+
+			code, _, stderr := runCommand("", "decrypt", "-key-file", keyFile, "-raw", "-in", encrypted, "-out", out)
+			require.Equal(t, 0, code, stderr)
+
+			info, err := os.Stat(out)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, files, 4, "no temporary files are left behind")
+		})
+
+		t.Run("StdinKey", func(t *testing.T) {
+			code, plaintext, stderr := runCommand(testSecretKey+"\n", "decrypt", "-key-file", "-", "-raw", "-in", encrypted)
+			require.Equal(t, 0, code, stderr)
+			assert.Equal(t, "file data", plaintext)
+
+			code, _, stderr = runCommand(testSecretKey+"\n", "decrypt", "-key-file", "-", "-raw")
+			assert.Equal(t, 1, code)
+			assert.Contains(t, stderr, "-in must name a file")
+		})
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		os.Setenv(secretKeyEnv, testSecretKey)
+		defer os.Unsetenv(secretKeyEnv)
+
+		code, ciphertext, _ := runCommand("env data", "encrypt")
+		require.Equal(t, 0, code)
+
+		code, plaintext, _ := runCommand(ciphertext, "decrypt")
+		require.Equal(t, 0, code)
+		assert.Equal(t, "env data", plaintext)
+	})
+
+	t.Run("NoKey", func(t *testing.T) {
+		code, _, stderr := runCommand("data", "encrypt")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "secret key is required")
+	})
+
+	t.Run("BadKeyLength", func(t *testing.T) {
+		code, _, stderr := runCommand("data", "encrypt", "-key", "short")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "must be 16, 24 or 32 bytes")
+	})
+
+	t.Run("NotBase64", func(t *testing.T) {
+		code, _, stderr := runCommand("%%%", "decrypt", "-key", testSecretKey)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "not base64")
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// readInput reads the named file, or stdin when name is empty or "-".
+func readInput(e *env, name string) ([]byte, error) {
+	if name == "" || name == "-" {
+		return ioutil.ReadAll(e.stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// writeOutput calls write with the named file, or stdout when name is empty or "-".
+// The file is written next to name and renamed over it once complete, so that
+// it ends up with mode even when it already existed and private material is
+// never world readable.
+func writeOutput(e *env, name string, mode os.FileMode, write func(w io.Writer) error) error {
+	if name == "" || name == "-" {
+		return write(e.stdout)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
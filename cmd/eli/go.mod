@@ -0,0 +1,17 @@
+module github.com/tizz98/eli/cmd/eli
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/pkg/errors v0.8.1
+	github.com/stretchr/objx v0.1.1 // indirect
+	github.com/stretchr/testify v1.3.0
+	github.com/tizz98/eli/auth v0.0.0-00010101000000-000000000000
+	github.com/tizz98/eli/crypto v0.0.0-20190304053131-e2d04ed3cbb6
+)
+
+// The CLI is built from the modules in this repository
+replace (
+	github.com/tizz98/eli/auth => ../../auth
+	github.com/tizz98/eli/crypto => ../../crypto
+)
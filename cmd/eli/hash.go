@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+// readPassword reads the first line of stdin without its line ending.
+func readPassword(e *env) ([]byte, error) {
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, errors.New("no password given on stdin")
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+func hash(e *env, args []string) error {
+	fs := newFlagSet(e, "hash")
+	algorithm := fs.String("algorithm", "bcrypt", "bcrypt, argon2id or scrypt")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	hasher, ok := map[string]crypto.PasswordHasher{
+		"argon2id": &crypto.Argon2idHasher{},
+		"scrypt":   &crypto.ScryptHasher{},
+		"bcrypt":   &crypto.BcryptHasher{},
+	}[*algorithm]
+	if !ok {
+		fmt.Fprintf(e.stderr, "eli hash: unknown algorithm %q\n", *algorithm)
+		return errUsage
+	}
+
+	password, err := readPassword(e)
+	if err != nil {
+		return err
+	}
+
+	hashed, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(e.stdout, string(hashed))
+	return nil
+}
+
+func verifyHash(e *env, args []string) error {
+	fs := newFlagSet(e, "verify-hash")
+	hashed := fs.String("hash", "", "the password hash")
+	hashFile := fs.String("hash-file", "", "file containing the password hash")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *hashFile != "" {
+		raw, err := ioutil.ReadFile(*hashFile)
+		if err != nil {
+			return err
+		}
+		*hashed = string(raw)
+	}
+
+	if *hashed == "" {
+		fmt.Fprintln(e.stderr, "eli verify-hash: -hash or -hash-file is required")
+		return errUsage
+	}
+
+	password, err := readPassword(e)
+	if err != nil {
+		return err
+	}
+
+	ok, needsRehash := crypto.VerifyPassword([]byte(strings.TrimSpace(*hashed)), password)
+	if !ok {
+		fmt.Fprintln(e.stdout, "mismatch")
+		return &exitError{1}
+	}
+
+	if needsRehash {
+		fmt.Fprintln(e.stdout, "ok, needs rehash")
+		return nil
+	}
+	fmt.Fprintln(e.stdout, "ok")
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestHash(t *testing.T) {
+	code, stdout, _ := runCommand("hunter2\n", "hash")
+	require.Equal(t, 0, code)
+	hashed := strings.TrimSpace(stdout)
+	assert.NotContains(t, hashed, "hunter2")
+
+	t.Run("Match", func(t *testing.T) {
+		code, stdout, _ := runCommand("hunter2\n", "verify-hash", "-hash", hashed)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "ok\n", stdout)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		code, stdout, _ := runCommand("hunter3\n", "verify-hash", "-hash", hashed)
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "mismatch\n", stdout)
+	})
+
+	t.Run("Algorithms", func(t *testing.T) {
+		for _, algorithm := range []string{"argon2id", "scrypt"} {
+			code, stdout, _ := runCommand("hunter2\n", "hash", "-algorithm", algorithm)
+			require.Equal(t, 0, code)
+
+			code, _, _ = runCommand("hunter2\n", "verify-hash", "-hash", strings.TrimSpace(stdout))
+			assert.Equal(t, 0, code, algorithm)
+		}
+
+		code, _, _ := runCommand("hunter2\n", "hash", "-algorithm", "md5")
+		assert.Equal(t, 2, code)
+	})
+
+	t.Run("NeedsRehash", func(t *testing.T) {
+		legacy, err := (&crypto.BcryptHasher{Cost: 4}).Hash([]byte("hunter2"))
+		require.NoError(t, err)
+
+		code, stdout, _ := runCommand("hunter2\n", "verify-hash", "-hash", string(legacy))
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "ok, needs rehash\n", stdout)
+	})
+
+	t.Run("NoPassword", func(t *testing.T) {
+		code, _, stderr := runCommand("", "hash")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "no password")
+	})
+
+	t.Run("NoHash", func(t *testing.T) {
+		code, _, _ := runCommand("hunter2\n", "verify-hash")
+		assert.Equal(t, 2, code)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func keygen(e *env, args []string) error {
+	fs := newFlagSet(e, "keygen")
+	out := fs.String("out", "", "write the private key PEM to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	key, err := crypto.GenerateRsaKey()
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(e, *out, 0600, func(w io.Writer) error {
+		return crypto.ExportRsaPrivateKeyAsPem(key, w)
+	})
+}
+
+func pubkey(e *env, args []string) error {
+	fs := newFlagSet(e, "pubkey")
+	in := fs.String("key", "", "private key PEM file, defaults to stdin")
+	out := fs.String("out", "", "write the public key PEM to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	pem, err := readInput(e, *in)
+	if err != nil {
+		return err
+	}
+
+	key, err := crypto.ParseRsaPrivateKeyFromPemStr(string(pem))
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(e, *out, 0644, func(w io.Writer) error {
+		return crypto.ExportRsaPublicKeyAsPem(key, w)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizz98/eli/crypto"
+)
+
+func TestKeygen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eli")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("Stdout", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "keygen")
+		require.Equal(t, 0, code)
+
+		_, err := crypto.ParseRsaPrivateKeyFromPemStr(stdout)
+		require.NoError(t, err)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		out := filepath.Join(dir, "key.pem")
+		code, stdout, _ := runCommand("", "keygen", "-out", out)
+		require.Equal(t, 0, code)
+		assert.Empty(t, stdout)
+
+		info, err := os.Stat(out)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+}
+
+func TestPubkey(t *testing.T) {
+	_, private, _ := runCommand("", "keygen")
+
+	code, stdout, _ := runCommand(private, "pubkey")
+	require.Equal(t, 0, code)
+
+	_, err := crypto.ParseRsaPublicKeyFromPemStr(stdout)
+	require.NoError(t, err)
+
+	code, _, stderr := runCommand("garbage", "pubkey")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "eli pubkey:")
+}
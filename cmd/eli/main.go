@@ -0,0 +1,101 @@
+// Command eli wraps the crypto and auth modules for use from the shell: generating
+// keys, signing and inspecting tokens, hashing passwords and encrypting data.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// env holds the standard streams so commands can be run from tests.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"keygen":      {"generate an RSA private key", keygen},
+	"pubkey":      {"export the public key of an RSA private key", pubkey},
+	"token":       {"sign, verify or decode JWTs (sign|verify|decode)", token},
+	"hash":        {"hash a password read from stdin", hash},
+	"verify-hash": {"check a password read from stdin against a hash", verifyHash},
+	"encrypt":     {"encrypt stdin or a file", encrypt},
+	"decrypt":     {"decrypt stdin or a file", decrypt},
+}
+
+// errUsage is returned when a command was called with invalid arguments, the
+// usage has already been printed.
+var errUsage = fmt.Errorf("invalid usage")
+
+// exitError is returned by commands that want a specific exit status without
+// printing anything more, e.g. verify-hash on a mismatch.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: eli <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
+
+func newFlagSet(e *env, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("eli "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+func run(e *env, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		usage(e.stderr)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(e.stderr, "eli: unknown command %q\n", args[0])
+		usage(e.stderr)
+		return 2
+	}
+
+	err := cmd.run(e, args[1:])
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case *exitError:
+		return err.code
+	}
+
+	if err == errUsage || err == flag.ErrHelp {
+		return 2
+	}
+	fmt.Fprintf(e.stderr, "eli %s: %s\n", args[0], strings.TrimSpace(err.Error()))
+	return 1
+}
+
+func main() {
+	os.Exit(run(&env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}, os.Args[1:]))
+}
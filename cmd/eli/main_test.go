@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand runs the CLI with stdin and returns the exit code, stdout and stderr.
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(&env{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr}, args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	t.Run("NoCommand", func(t *testing.T) {
+		code, _, stderr := runCommand("")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, "usage: eli")
+	})
+
+	t.Run("UnknownCommand", func(t *testing.T) {
+		code, _, stderr := runCommand("", "nope")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, `unknown command "nope"`)
+	})
+
+	t.Run("BadFlag", func(t *testing.T) {
+		code, _, _ := runCommand("", "hash", "-nope")
+		assert.Equal(t, 2, code)
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"github.com/tizz98/eli/auth"
+	"github.com/tizz98/eli/crypto"
+)
+
+func token(e *env, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(e.stderr, "usage: eli token sign|verify|decode [flags]")
+		return errUsage
+	}
+
+	switch args[0] {
+	case "sign":
+		return tokenSign(e, args[1:])
+	case "verify":
+		return tokenVerify(e, args[1:])
+	case "decode":
+		return tokenDecode(e, args[1:])
+	default:
+		fmt.Fprintf(e.stderr, "eli token: unknown subcommand %q\n", args[0])
+		return errUsage
+	}
+}
+
+// readPublicKey loads an RSA public key from a PEM file holding either the
+// public or the private key.
+func readPublicKey(name string) (*rsa.PublicKey, error) {
+	pem, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub, err := crypto.ParseRsaPublicKeyFromPemStr(string(pem)); err == nil {
+		return pub, nil
+	}
+
+	key, err := crypto.ParseRsaPrivateKeyFromPemStr(string(pem))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is neither an RSA public nor private key", name)
+	}
+	return &key.PublicKey, nil
+}
+
+// readToken takes the token from the first argument or stdin.
+func readToken(e *env, args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	raw, err := ioutil.ReadAll(e.stdin)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", errors.New("no token given")
+	}
+	return token, nil
+}
+
+func tokenSign(e *env, args []string) error {
+	fs := newFlagSet(e, "token sign")
+	keyFile := fs.String("key", "", "private key PEM file (required)")
+	claimsJSON := fs.String("claims", "{}", "claims as a JSON object")
+	sub := fs.String("sub", "", "subject claim")
+	iss := fs.String("iss", "", "issuer claim")
+	aud := fs.String("aud", "", "audience claim")
+	ttl := fs.Duration("ttl", time.Hour, "lifetime of the token, 0 for no expiry")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *keyFile == "" {
+		fmt.Fprintln(e.stderr, "eli token sign: -key is required")
+		return errUsage
+	}
+
+	pem, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := crypto.ParseRsaPrivateKeyFromPemStr(string(pem))
+	if err != nil {
+		return err
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal([]byte(*claimsJSON), &claims); err != nil {
+		return errors.Wrap(err, "invalid -claims")
+	}
+
+	for name, value := range map[string]string{"sub": *sub, "iss": *iss, "aud": *aud} {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	if *ttl > 0 {
+		claims["exp"] = now.Add(*ttl).Unix()
+	}
+
+	signed, err := auth.NewJWTWithClaims(claims, key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(e.stdout, signed)
+	return nil
+}
+
+func tokenVerify(e *env, args []string) error {
+	fs := newFlagSet(e, "token verify")
+	keyFile := fs.String("key", "", "public (or private) key PEM file (required)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *keyFile == "" {
+		fmt.Fprintln(e.stderr, "eli token verify: -key is required")
+		return errUsage
+	}
+
+	pub, err := readPublicKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	raw, err := readToken(e, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	parsed, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS512 {
+			return nil, fmt.Errorf("expected %s signing method but token specified %v", jwt.SigningMethodRS512.Alg(), token.Header["alg"])
+		}
+		return pub, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "token is not valid")
+	}
+
+	out, err := json.MarshalIndent(parsed.Claims, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, string(out))
+	return nil
+}
+
+func tokenDecode(e *env, args []string) error {
+	fs := newFlagSet(e, "token decode")
+	keyFile := fs.String("key", "", "also verify the signature with this public (or private) key PEM file")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	raw, err := readToken(e, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return errors.New("token must have three dot separated parts")
+	}
+
+	header, err := decodeSegment(parts[0])
+	if err != nil {
+		return errors.Wrap(err, "invalid header")
+	}
+
+	claims, err := decodeSegment(parts[1])
+	if err != nil {
+		return errors.Wrap(err, "invalid claims")
+	}
+
+	for _, section := range []struct {
+		name  string
+		value map[string]interface{}
+	}{{"Header", header}, {"Claims", claims}} {
+		out, err := json.MarshalIndent(section.value, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s:\n%s\n\n", section.name, out)
+	}
+
+	fmt.Fprintln(e.stdout, "Validity:")
+	problems := validityReport(e, claims, time.Now())
+
+	if *keyFile == "" {
+		fmt.Fprintln(e.stdout, "  signature: not checked, pass -key to verify it")
+	} else {
+		pub, err := readPublicKey(*keyFile)
+		if err != nil {
+			return err
+		}
+
+		method := jwt.GetSigningMethod(fmt.Sprint(header["alg"]))
+		if method == nil {
+			fmt.Fprintf(e.stdout, "  signature: unsupported algorithm %v\n", header["alg"])
+			problems++
+		} else if err := method.Verify(strings.Join(parts[:2], "."), parts[2], pub); err != nil {
+			fmt.Fprintf(e.stdout, "  signature: INVALID (%s)\n", err)
+			problems++
+		} else {
+			fmt.Fprintln(e.stdout, "  signature: valid")
+		}
+	}
+
+	if problems > 0 {
+		return &exitError{1}
+	}
+	return nil
+}
+
+func decodeSegment(segment string) (map[string]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// validityReport prints the time based claims and returns how many of them make
+// the token unusable at now.
+func validityReport(e *env, claims map[string]interface{}, now time.Time) int {
+	problems := 0
+	report := func(name string, check func(t time.Time) string) {
+		value, ok := claims[name]
+		if !ok {
+			fmt.Fprintf(e.stdout, "  %s: not set\n", name)
+			return
+		}
+
+		var t time.Time
+		switch value := value.(type) {
+		case float64:
+			t = time.Unix(int64(value), 0)
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				fmt.Fprintf(e.stdout, "  %s: unreadable value %q\n", name, value)
+				problems++
+				return
+			}
+			t = parsed
+		default:
+			fmt.Fprintf(e.stdout, "  %s: unreadable value %v\n", name, value)
+			problems++
+			return
+		}
+
+		status := check(t)
+		fmt.Fprintf(e.stdout, "  %s: %s (%s)\n", name, t.UTC().Format(time.RFC3339), status)
+	}
+
+	report("iat", func(t time.Time) string {
+		if t.After(now) {
+			problems++
+			return "in the future"
+		}
+		return now.Sub(t).Round(time.Second).String() + " ago"
+	})
+	report("nbf", func(t time.Time) string {
+		if t.After(now) {
+			problems++
+			return "NOT YET VALID"
+		}
+		return "ok"
+	})
+	report("exp", func(t time.Time) string {
+		if !t.After(now) {
+			problems++
+			return "EXPIRED " + now.Sub(t).Round(time.Second).String() + " ago"
+		}
+		return "expires in " + t.Sub(now).Round(time.Second).String()
+	})
+	return problems
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eli")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	privateFile := filepath.Join(dir, "key.pem")
+	publicFile := filepath.Join(dir, "key.pub.pem")
+	otherFile := filepath.Join(dir, "other.pem")
+	require.Equal(t, 0, func() int { c, _, _ := runCommand("", "keygen", "-out", privateFile); return c }())
+	require.Equal(t, 0, func() int { c, _, _ := runCommand("", "keygen", "-out", otherFile); return c }())
+	require.Equal(t, 0, func() int { c, _, _ := runCommand("", "pubkey", "-key", privateFile, "-out", publicFile); return c }())
+
+	code, stdout, stderr := runCommand("", "token", "sign", "-key", privateFile, "-sub", "user-1", "-claims", `{"role":"admin"}`)
+	require.Equal(t, 0, code, stderr)
+	token := strings.TrimSpace(stdout)
+
+	t.Run("Verify", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "token", "verify", "-key", publicFile, token)
+		require.Equal(t, 0, code)
+
+		var claims map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(stdout), &claims))
+		assert.Equal(t, "user-1", claims["sub"])
+		assert.Equal(t, "admin", claims["role"])
+	})
+
+	t.Run("VerifyStdin", func(t *testing.T) {
+		code, _, _ := runCommand(token+"\n", "token", "verify", "-key", privateFile)
+		require.Equal(t, 0, code)
+	})
+
+	t.Run("VerifyWrongKey", func(t *testing.T) {
+		code, _, stderr := runCommand("", "token", "verify", "-key", otherFile, token)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "token is not valid")
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "token", "decode", "-key", publicFile, token)
+		require.Equal(t, 0, code, stdout)
+		assert.Contains(t, stdout, `"alg": "RS512"`)
+		assert.Contains(t, stdout, `"sub": "user-1"`)
+		assert.Contains(t, stdout, "exp: ")
+		assert.Contains(t, stdout, "expires in")
+		assert.Contains(t, stdout, "signature: valid")
+	})
+
+	t.Run("DecodeExpired", func(t *testing.T) {
+		_, stdout, _ := runCommand("", "token", "sign", "-key", privateFile, "-claims", `{"exp":1}`, "-ttl", "0")
+		code, stdout, _ := runCommand("", "token", "decode", strings.TrimSpace(stdout))
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stdout, "EXPIRED")
+		assert.Contains(t, stdout, "signature: not checked")
+	})
+
+	t.Run("DecodeBadSignature", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "token", "decode", "-key", otherFile, token)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stdout, "signature: INVALID")
+	})
+
+	t.Run("DecodeMalformed", func(t *testing.T) {
+		code, _, stderr := runCommand("", "token", "decode", "abc")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "three dot separated parts")
+	})
+
+	t.Run("SignWithoutKey", func(t *testing.T) {
+		code, _, _ := runCommand("", "token", "sign")
+		assert.Equal(t, 2, code)
+	})
+}
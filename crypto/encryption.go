@@ -1,49 +1,157 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"io"
 )
 
-// Functions based on: https://stackoverflow.com/questions/18817336/golang-encrypting-a-string-with-aes-and-base64
+var (
+	// ErrTruncated is returned for ciphertexts too short to hold a header, nonce and tag.
+	ErrTruncated = errors.New("crypto: ciphertext is truncated")
+	// ErrTampered is returned when a ciphertext or its associated data were modified,
+	// or the wrong key is used.
+	ErrTampered = errors.New("crypto: message authentication failed")
+	// ErrUnsupportedVersion is returned for ciphertexts written by a newer format version.
+	ErrUnsupportedVersion = errors.New("crypto: unsupported ciphertext version")
+)
 
-// secretKey should be 16, 24, or 32 bytes
-func Encrypt(value, secretKey []byte) ([]byte, error) {
+// Sealed ciphertexts start with sealMagic followed by a version byte. Version 1
+// is AES-GCM with a 12 byte random nonce:
+//
+//	magic (2) | version (1) | nonce (12) | ciphertext | tag (16)
+var sealMagic = []byte{0xe1, 0x1c}
+
+const (
+	sealVersion1   = 1
+	sealHeaderSize = 3
+)
+
+func newGCM(secretKey []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(secretKey)
 	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	b := base64.StdEncoding.EncodeToString(value)
-	ciphertext := make([]byte, aes.BlockSize+len(b))
+func hasSealHeader(ciphertext []byte) bool {
+	return len(ciphertext) >= sealHeaderSize && bytes.Equal(ciphertext[:len(sealMagic)], sealMagic)
+}
 
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+// Seal encrypts and authenticates plaintext with AES-GCM. additionalData is
+// authenticated but not encrypted, the same value must be passed to Open.
+// secretKey should be 16, 24, or 32 bytes.
+func Seal(plaintext, secretKey, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(secretKey)
+	if err != nil {
 		return nil, err
 	}
 
-	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(b))
+	out := make([]byte, sealHeaderSize+gcm.NonceSize(), sealHeaderSize+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	copy(out, sealMagic)
+	out[len(sealMagic)] = sealVersion1
+
+	nonce := out[sealHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
 
-	return ciphertext, nil
+	// The header is authenticated too, so that the version can't be swapped
+	return gcm.Seal(out, nonce, plaintext, append(out[:sealHeaderSize:sealHeaderSize], additionalData...)), nil
 }
 
+// Open decrypts a ciphertext created by Seal.
+func Open(ciphertext, secretKey, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < sealHeaderSize {
+		return nil, ErrTruncated
+	}
+
+	if !hasSealHeader(ciphertext) || ciphertext[len(sealMagic)] != sealVersion1 {
+		return nil, ErrUnsupportedVersion
+	}
+
+	gcm, err := newGCM(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < sealHeaderSize+gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrTruncated
+	}
+
+	header := ciphertext[:sealHeaderSize:sealHeaderSize]
+	nonce := ciphertext[sealHeaderSize : sealHeaderSize+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[sealHeaderSize+gcm.NonceSize():], append(header, additionalData...))
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return plaintext, nil
+}
+
+// Encrypt is Seal without associated data.
+// secretKey should be 16, 24, or 32 bytes
+func Encrypt(value, secretKey []byte) ([]byte, error) {
+	return Seal(value, secretKey, nil)
+}
+
+// Decrypt reads ciphertexts of Encrypt and Seal (without associated data), as
+// well as the unauthenticated AES-CFB ciphertexts older versions of Encrypt
+// wrote, so stored values can be migrated by decrypting and encrypting them again.
+// Values starting with the sealed header of version 1 are never read as legacy
+// ones, an authentication failure is always reported as such. See DecryptLegacy
+// for the rare legacy values that start with it.
 // secretKey should be 16, 24, or 32 bytes
 func Decrypt(encryptedValue, secretKey []byte) ([]byte, error) {
+	if !hasSealHeader(encryptedValue) {
+		return decryptCFB(encryptedValue, secretKey)
+	}
+
+	if encryptedValue[len(sealMagic)] == sealVersion1 {
+		return Open(encryptedValue, secretKey, nil)
+	}
+
+	// Not a version Decrypt reads, but a legacy IV can start with the magic bytes by chance
+	if legacy, err := decryptCFB(encryptedValue, secretKey); err == nil {
+		return legacy, nil
+	}
+	return nil, ErrUnsupportedVersion
+}
+
+// DecryptLegacy decrypts the AES-CFB ciphertexts older versions of Encrypt
+// wrote. Decrypt reads those too, except for about one in 16 million whose
+// random IV happens to start with the sealed header of version 1, Decrypt
+// returns ErrTampered for them. Use DecryptLegacy for values known to predate
+// Seal that Decrypt rejects.
+// secretKey should be 16, 24, or 32 bytes
+func DecryptLegacy(encryptedValue, secretKey []byte) ([]byte, error) {
+	return decryptCFB(encryptedValue, secretKey)
+}
+
+// Based on: https://stackoverflow.com/questions/18817336/golang-encrypting-a-string-with-aes-and-base64
+
+// decryptCFB decrypts the legacy format: IV followed by the AES-CFB encrypted
+// base64 encoding of the value.
+func decryptCFB(encryptedValue, secretKey []byte) ([]byte, error) {
 	block, err := aes.NewCipher(secretKey)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(encryptedValue) < aes.BlockSize {
+		return nil, ErrTruncated
+	}
+
 	iv := encryptedValue[:aes.BlockSize]
-	encryptedValue = encryptedValue[aes.BlockSize:]
+	decrypted := make([]byte, len(encryptedValue)-aes.BlockSize)
 
 	cfb := cipher.NewCFBDecrypter(block, iv)
-	cfb.XORKeyStream(encryptedValue, encryptedValue)
-	data, err := base64.StdEncoding.DecodeString(string(encryptedValue))
+	cfb.XORKeyStream(decrypted, encryptedValue[aes.BlockSize:])
+	data, err := base64.StdEncoding.DecodeString(string(decrypted))
 
 	if err != nil {
 		return nil, err
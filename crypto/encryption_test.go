@@ -1,6 +1,11 @@
 package crypto
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,6 +14,29 @@ import (
 
 var key = []byte("o4H845smMQNOOXmELqpAClvsW5dDVEJa")
 
+// encryptCFB writes the legacy format of Encrypt.
+func encryptCFB(t *testing.T, value, secretKey []byte) []byte {
+	return encryptCFBWithIVPrefix(t, value, secretKey, nil)
+}
+
+// encryptCFBWithIVPrefix is encryptCFB with a random IV that starts with prefix.
+func encryptCFBWithIVPrefix(t *testing.T, value, secretKey, prefix []byte) []byte {
+	block, err := aes.NewCipher(secretKey)
+	require.NoError(t, err)
+
+	b := base64.StdEncoding.EncodeToString(value)
+	ciphertext := make([]byte, aes.BlockSize+len(b))
+
+	iv := ciphertext[:aes.BlockSize]
+	_, err = io.ReadFull(rand.Reader, iv)
+	require.NoError(t, err)
+	copy(iv, prefix)
+
+	cfb := cipher.NewCFBEncrypter(block, iv)
+	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(b))
+	return ciphertext
+}
+
 func TestEncrypt(t *testing.T) {
 	encrypted, err := Encrypt([]byte("foo"), key)
 	require.NoError(t, err)
@@ -23,4 +51,109 @@ func TestDecrypt(t *testing.T) {
 	decrypted, err := Decrypt(encrypted, key)
 	require.NoError(t, err)
 	assert.Equal(t, "foo", string(decrypted))
+
+	t.Run("Legacy", func(t *testing.T) {
+		legacy := encryptCFB(t, []byte("foo"), key)
+		original := append([]byte{}, legacy...)
+
+		decrypted, err := Decrypt(legacy, key)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(decrypted))
+		assert.Equal(t, original, legacy, "input must not be modified")
+
+		t.Run("MagicIV", func(t *testing.T) {
+			// About one in 65536 legacy IVs start with the magic bytes
+			for _, version := range []byte{0, 2, 4, 5, 0xff} {
+				legacy := encryptCFBWithIVPrefix(t, []byte("foo"), key, append(append([]byte{}, sealMagic...), version))
+
+				decrypted, err := Decrypt(legacy, key)
+				require.NoError(t, err, "version %d", version)
+				assert.Equal(t, "foo", string(decrypted))
+			}
+
+			// Those that look like version 1 are only read by DecryptLegacy
+			legacy := encryptCFBWithIVPrefix(t, []byte("a longer legacy value"), key, append(append([]byte{}, sealMagic...), sealVersion1))
+			_, err := Decrypt(legacy, key)
+			assert.Equal(t, ErrTampered, err)
+
+			decrypted, err := DecryptLegacy(legacy, key)
+			require.NoError(t, err)
+			assert.Equal(t, "a longer legacy value", string(decrypted))
+		})
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		sealed, err := Seal([]byte("foo"), key, nil)
+		require.NoError(t, err)
+		sealed[len(sealMagic)] = 9
+
+		_, err = Decrypt(sealed, key)
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	})
+
+	t.Run("Short", func(t *testing.T) {
+		for _, input := range [][]byte{nil, {1, 2, 3}, sealMagic} {
+			_, err := Decrypt(input, key)
+			assert.Equal(t, ErrTruncated, err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		encrypted[len(encrypted)-1] ^= 1
+		_, err := Decrypt(encrypted, key)
+		assert.Equal(t, ErrTampered, err)
+	})
+}
+
+func TestSeal(t *testing.T) {
+	sealed, err := Seal([]byte("secret"), key, []byte("user:42"))
+	require.NoError(t, err)
+	assert.Equal(t, sealMagic, sealed[:2])
+	assert.Equal(t, byte(sealVersion1), sealed[2])
+
+	again, err := Seal([]byte("secret"), key, []byte("user:42"))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "nonces must be random")
+
+	t.Run("Open", func(t *testing.T) {
+		opened, err := Open(sealed, key, []byte("user:42"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(opened))
+	})
+
+	t.Run("WrongAdditionalData", func(t *testing.T) {
+		_, err := Open(sealed, key, []byte("user:43"))
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		_, err := Open(sealed, []byte("0123456789abcdef0123456789abcdef"), []byte("user:42"))
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		for i := range sealed[sealHeaderSize:] {
+			tampered := append([]byte{}, sealed...)
+			tampered[sealHeaderSize+i] ^= 0x80
+			_, err := Open(tampered, key, []byte("user:42"))
+			assert.Equal(t, ErrTampered, err)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		for n := 0; n < len(sealed)-len("secret"); n++ {
+			_, err := Open(sealed[:n], key, []byte("user:42"))
+			assert.Error(t, err)
+			if n >= sealHeaderSize {
+				assert.Equal(t, ErrTruncated, err)
+			}
+		}
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		future := append([]byte{}, sealed...)
+		future[2] = 2
+		_, err := Open(future, key, []byte("user:42"))
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	})
 }
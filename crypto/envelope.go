@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// KeyManager wraps and unwraps data keys with key-encryption keys it holds, like
+// a cloud KMS does. Key-encryption keys never leave the KeyManager.
+type KeyManager interface {
+	// WrapKey encrypts dataKey with the key-encryption key keyID
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped by WrapKey with the same keyID
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned by key managers for unknown key IDs.
+var ErrKeyNotFound = errors.New("crypto: key not found")
+
+// Envelopes use the sealMagic followed by version 3, the key ID, the wrapped
+// data key and the message sealed with the data key:
+//
+//	magic (2) | version (3) | key ID length (1) | key ID | wrapped key length (2) | wrapped key | Seal output
+//
+// Everything before the Seal output is part of its associated data.
+const (
+	sealVersionEnvelope = 3
+	envelopeDataKeySize = 32
+)
+
+type EnvelopeOptions struct {
+	// Required, holds the key-encryption keys
+	KeyManager KeyManager
+	// Required, the key-encryption key new messages are encrypted with
+	KeyID string
+}
+
+// Envelope encrypts every message with a new random data key, which is wrapped
+// by a KeyManager and stored next to the ciphertext.
+type Envelope struct {
+	Options EnvelopeOptions
+}
+
+func NewEnvelope(options EnvelopeOptions) *Envelope {
+	if options.KeyManager == nil {
+		panic("crypto: a key manager is required")
+	}
+	if options.KeyID == "" || len(options.KeyID) > 255 {
+		panic("crypto: a key ID of at most 255 bytes is required")
+	}
+	return &Envelope{Options: options}
+}
+
+// Encrypt encrypts plaintext with a new data key wrapped by the KeyID key.
+// additionalData is authenticated but not encrypted, the same value must be
+// passed to Decrypt.
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, additionalData []byte) ([]byte, error) {
+	dataKey := make([]byte, envelopeDataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := e.Options.KeyManager.WrapKey(ctx, e.Options.KeyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > 0xffff {
+		return nil, errors.New("crypto: wrapped key is too long")
+	}
+
+	header := make([]byte, 0, sealHeaderSize+1+len(e.Options.KeyID)+2+len(wrapped))
+	header = append(header, sealMagic...)
+	header = append(header, sealVersionEnvelope, byte(len(e.Options.KeyID)))
+	header = append(header, e.Options.KeyID...)
+	header = append(header, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	sealed, err := Seal(plaintext, dataKey, append(header[:len(header):len(header)], additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// Decrypt decrypts a ciphertext of Encrypt, unwrapping its data key with the
+// key ID stored in it, which doesn't need to be the current KeyID.
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext, additionalData []byte) ([]byte, error) {
+	env, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := e.Options.KeyManager.UnwrapKey(ctx, env.keyID, env.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return Open(env.sealed, dataKey, append(env.header[:len(env.header):len(env.header)], additionalData...))
+}
+
+// EnvelopeKeyID returns the ID of the key-encryption key that wrapped the data
+// key of an Envelope ciphertext.
+func EnvelopeKeyID(ciphertext []byte) (string, error) {
+	env, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return env.keyID, nil
+}
+
+type envelope struct {
+	header     []byte
+	keyID      string
+	wrappedKey []byte
+	sealed     []byte
+}
+
+func parseEnvelope(ciphertext []byte) (*envelope, error) {
+	if len(ciphertext) < sealHeaderSize+1 {
+		return nil, ErrTruncated
+	}
+	if !hasSealHeader(ciphertext) || ciphertext[len(sealMagic)] != sealVersionEnvelope {
+		return nil, ErrUnsupportedVersion
+	}
+
+	rest := ciphertext[sealHeaderSize:]
+	keyIDLength := int(rest[0])
+	if len(rest) < 1+keyIDLength+2 {
+		return nil, ErrTruncated
+	}
+	keyID := string(rest[1 : 1+keyIDLength])
+	rest = rest[1+keyIDLength:]
+
+	wrappedLength := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+wrappedLength {
+		return nil, ErrTruncated
+	}
+
+	headerSize := len(ciphertext) - len(rest) + 2 + wrappedLength
+	return &envelope{
+		header:     ciphertext[:headerSize],
+		keyID:      keyID,
+		wrappedKey: rest[2 : 2+wrappedLength],
+		sealed:     ciphertext[headerSize:],
+	}, nil
+}
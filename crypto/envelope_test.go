@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKeyManager returns a LocalKeyManager with the given keys in a temporary directory.
+func newTestKeyManager(t *testing.T, keyIDs ...string) *LocalKeyManager {
+	dir, err := ioutil.TempDir("", "eli-kms")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	m, err := OpenLocalKeyManager(filepath.Join(dir, "keys.json"))
+	require.NoError(t, err)
+	for _, id := range keyIDs {
+		require.NoError(t, m.CreateKey(id))
+	}
+	return m
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	m := newTestKeyManager(t, "kek-1", "kek-2")
+	e := NewEnvelope(EnvelopeOptions{KeyManager: m, KeyID: "kek-1"})
+
+	ciphertext, err := e.Encrypt(ctx, []byte("account number"), []byte("user:42"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "account number")
+
+	keyID, err := EnvelopeKeyID(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "kek-1", keyID)
+
+	again, err := e.Encrypt(ctx, []byte("account number"), []byte("user:42"))
+	require.NoError(t, err)
+	assert.NotEqual(t, ciphertext[:60], again[:60], "data keys must be random")
+
+	t.Run("Decrypt", func(t *testing.T) {
+		plaintext, err := e.Decrypt(ctx, ciphertext, []byte("user:42"))
+		require.NoError(t, err)
+		assert.Equal(t, "account number", string(plaintext))
+	})
+
+	t.Run("OtherKeyID", func(t *testing.T) {
+		// Ciphertexts name their key, so changing KeyID keeps old ones readable
+		e2 := NewEnvelope(EnvelopeOptions{KeyManager: m, KeyID: "kek-2"})
+		plaintext, err := e2.Decrypt(ctx, ciphertext, []byte("user:42"))
+		require.NoError(t, err)
+		assert.Equal(t, "account number", string(plaintext))
+	})
+
+	t.Run("WrongAdditionalData", func(t *testing.T) {
+		_, err := e.Decrypt(ctx, ciphertext, []byte("user:43"))
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		for i := sealHeaderSize; i < len(ciphertext); i++ {
+			tampered := append([]byte{}, ciphertext...)
+			tampered[i] ^= 1
+			_, err := e.Decrypt(ctx, tampered, []byte("user:42"))
+			assert.Error(t, err, "byte %d", i)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		for n := 0; n < len(ciphertext); n++ {
+			_, err := e.Decrypt(ctx, ciphertext[:n], []byte("user:42"))
+			assert.Error(t, err, "length %d", n)
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		other := NewEnvelope(EnvelopeOptions{KeyManager: newTestKeyManager(t, "kek-1"), KeyID: "kek-1"})
+		_, err := other.Decrypt(ctx, ciphertext, []byte("user:42"))
+		assert.Equal(t, ErrTampered, err)
+
+		_, err = NewEnvelope(EnvelopeOptions{KeyManager: m, KeyID: "kek-3"}).Encrypt(ctx, []byte("x"), nil)
+		assert.Equal(t, ErrKeyNotFound, err)
+	})
+
+	t.Run("NotAnEnvelope", func(t *testing.T) {
+		sealed, err := Seal([]byte("secret"), key, nil)
+		require.NoError(t, err)
+		_, err = e.Decrypt(ctx, sealed, nil)
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		assert.Panics(t, func() { NewEnvelope(EnvelopeOptions{KeyID: "kek-1"}) })
+		assert.Panics(t, func() { NewEnvelope(EnvelopeOptions{KeyManager: m}) })
+	})
+}
@@ -1,12 +1,339 @@
 package crypto
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrPasswordTooLong is returned by BcryptHasher for passwords bcrypt would truncate.
+	ErrPasswordTooLong = errors.New("crypto: password is longer than 72 bytes")
+	// ErrUnsupportedHash is returned for hashes in an unknown format.
+	ErrUnsupportedHash = errors.New("crypto: unsupported password hash format")
+)
+
+// PasswordHasher creates and verifies password hashes of one algorithm.
+type PasswordHasher interface {
+	// Hash returns the hash of password in PHC string format (bcrypt hashes keep their own $2a$ format)
+	Hash(password []byte) ([]byte, error)
+	// Verify reports whether password matches a hash created by this algorithm
+	Verify(hashed, password []byte) (bool, error)
+	// NeedsRehash reports whether hashed was created by another algorithm or with other parameters
+	NeedsRehash(hashed []byte) bool
+}
+
+// DefaultPasswordHasher is used by GeneratePasswordHash and NeedsRehash. It is
+// bcrypt with the default cost, set it to an Argon2idHasher to opt into Argon2id.
+var DefaultPasswordHasher PasswordHasher = &BcryptHasher{}
+
+var phcEncoding = base64.RawStdEncoding
+
+func newSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// phcHash is a decoded PHC string: $id$[v=version$]params$salt$hash
+type phcHash struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(hashed []byte) (*phcHash, error) {
+	fields := strings.Split(string(hashed), "$")
+	if len(fields) < 5 || fields[0] != "" {
+		return nil, ErrUnsupportedHash
+	}
+
+	phc := &phcHash{id: fields[1], params: map[string]string{}}
+	fields = fields[2:]
+	if strings.HasPrefix(fields[0], "v=") {
+		phc.version = strings.TrimPrefix(fields[0], "v=")
+		fields = fields[1:]
+	}
+
+	if len(fields) != 3 {
+		return nil, ErrUnsupportedHash
+	}
+
+	for _, param := range strings.Split(fields[0], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrUnsupportedHash
+		}
+		phc.params[kv[0]] = kv[1]
+	}
+
+	var err error
+	if phc.salt, err = phcEncoding.DecodeString(fields[1]); err != nil {
+		return nil, ErrUnsupportedHash
+	}
+	if phc.hash, err = phcEncoding.DecodeString(fields[2]); err != nil || len(phc.hash) == 0 {
+		return nil, ErrUnsupportedHash
+	}
+	return phc, nil
+}
+
+// uintParams reads the named numeric parameters of a PHC string.
+func (phc *phcHash) uintParams(names ...string) ([]uint64, error) {
+	values := make([]uint64, len(names))
+	for i, name := range names {
+		v, err := strconv.ParseUint(phc.params[name], 10, 32)
+		if err != nil || v == 0 {
+			return nil, ErrUnsupportedHash
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Argon2idHasher hashes passwords with Argon2id (RFC 9106). Zero fields use the
+// defaults given below.
+type Argon2idHasher struct {
+	// Number of passes, defaults to 3
+	Time uint32
+	// Memory in KiB, defaults to 64 MiB
+	Memory uint32
+	// Degree of parallelism, defaults to 4
+	Threads uint8
+	// Length of the salt, defaults to 16
+	SaltLength int
+	// Length of the hash, defaults to 32
+	KeyLength uint32
+}
+
+func (h *Argon2idHasher) params() Argon2idHasher {
+	p := *h
+	if p.Time == 0 {
+		p.Time = 3
+	}
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Threads == 0 {
+		p.Threads = 4
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	return p
+}
+
+func (h *Argon2idHasher) Hash(password []byte) ([]byte, error) {
+	p := h.params()
+	salt, err := newSalt(p.SaltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))), nil
+}
+
+func (h *Argon2idHasher) decode(hashed []byte) (*phcHash, []uint64, error) {
+	phc, err := parsePHC(hashed)
+	if err != nil || phc.id != "argon2id" || phc.version != strconv.Itoa(argon2.Version) {
+		return nil, nil, ErrUnsupportedHash
+	}
+
+	// Memory, time and threads
+	values, err := phc.uintParams("m", "t", "p")
+	if err != nil || values[2] > 255 {
+		return nil, nil, ErrUnsupportedHash
+	}
+	return phc, values, nil
+}
+
+func (h *Argon2idHasher) Verify(hashed, password []byte) (bool, error) {
+	phc, values, err := h.decode(hashed)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey(password, phc.salt, uint32(values[1]), uint32(values[0]), uint8(values[2]), uint32(len(phc.hash)))
+	return subtle.ConstantTimeCompare(key, phc.hash) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hashed []byte) bool {
+	phc, values, err := h.decode(hashed)
+	if err != nil {
+		return true
+	}
+
+	p := h.params()
+	return values[0] != uint64(p.Memory) || values[1] != uint64(p.Time) || values[2] != uint64(p.Threads) ||
+		len(phc.salt) != p.SaltLength || len(phc.hash) != int(p.KeyLength)
+}
+
+// ScryptHasher hashes passwords with scrypt. Zero fields use the defaults given below.
+type ScryptHasher struct {
+	// log2 of the CPU/memory cost N, defaults to 15
+	LogN uint8
+	// Block size, defaults to 8
+	R int
+	// Parallelization, defaults to 1
+	P int
+	// Length of the salt, defaults to 16
+	SaltLength int
+	// Length of the hash, defaults to 32
+	KeyLength int
+}
+
+func (h *ScryptHasher) params() ScryptHasher {
+	p := *h
+	if p.LogN == 0 {
+		p.LogN = 15
+	}
+	if p.R == 0 {
+		p.R = 8
+	}
+	if p.P == 0 {
+		p.P = 1
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	return p
+}
+
+func (h *ScryptHasher) Hash(password []byte) ([]byte, error) {
+	p := h.params()
+	salt, err := newSalt(p.SaltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(password, salt, 1<<p.LogN, p.R, p.P, p.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", p.LogN, p.R, p.P,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))), nil
+}
+
+func (h *ScryptHasher) decode(hashed []byte) (*phcHash, []uint64, error) {
+	phc, err := parsePHC(hashed)
+	if err != nil || phc.id != "scrypt" {
+		return nil, nil, ErrUnsupportedHash
+	}
+
+	// log2(N), r and p
+	values, err := phc.uintParams("ln", "r", "p")
+	if err != nil || values[0] > 31 {
+		return nil, nil, ErrUnsupportedHash
+	}
+	return phc, values, nil
+}
+
+func (h *ScryptHasher) Verify(hashed, password []byte) (bool, error) {
+	phc, values, err := h.decode(hashed)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key(password, phc.salt, 1<<values[0], int(values[1]), int(values[2]), len(phc.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, phc.hash) == 1, nil
+}
+
+func (h *ScryptHasher) NeedsRehash(hashed []byte) bool {
+	phc, values, err := h.decode(hashed)
+	if err != nil {
+		return true
+	}
+
+	p := h.params()
+	return values[0] != uint64(p.LogN) || values[1] != uint64(p.R) || values[2] != uint64(p.P) ||
+		len(phc.salt) != p.SaltLength || len(phc.hash) != p.KeyLength
+}
+
+// BcryptHasher hashes passwords with bcrypt. Unlike bcrypt itself it refuses
+// passwords longer than 72 bytes instead of ignoring the rest of them.
+type BcryptHasher struct {
+	// Defaults to bcrypt.DefaultCost
+	Cost int
+}
+
+func (h *BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
+
+func isBcryptHash(hashed []byte) bool {
+	return bytes.HasPrefix(hashed, []byte("$2a$")) || bytes.HasPrefix(hashed, []byte("$2b$")) || bytes.HasPrefix(hashed, []byte("$2y$"))
+}
+
+func (h *BcryptHasher) Hash(password []byte) ([]byte, error) {
+	if len(password) > 72 {
+		return nil, ErrPasswordTooLong
+	}
+	return bcrypt.GenerateFromPassword(password, h.cost())
+}
+
+func (h *BcryptHasher) Verify(hashed, password []byte) (bool, error) {
+	if !isBcryptHash(hashed) {
+		return false, ErrUnsupportedHash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hashed, password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(hashed []byte) bool {
+	if !isBcryptHash(hashed) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(hashed)
+	return err != nil || cost != h.cost()
+}
+
+// GeneratePasswordHash hashes password with DefaultPasswordHasher.
 func GeneratePasswordHash(password []byte) ([]byte, error) {
-	return bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	return DefaultPasswordHasher.Hash(password)
 }
 
+// ComparePasswordHash reports whether givenPassword matches hashedPassword, which
+// can be in any format of DefaultVerifierRegistry. Use VerifyPassword to also
+// learn whether the hash should be upgraded.
 func ComparePasswordHash(hashedPassword, givenPassword []byte) bool {
-	err := bcrypt.CompareHashAndPassword(hashedPassword, givenPassword)
-	return err == nil
+	ok, _ := VerifyPassword(hashedPassword, givenPassword)
+	return ok
+}
+
+// NeedsRehash reports whether hashedPassword should be replaced by a new hash
+// of DefaultPasswordHasher, which is best done right after a successful login.
+func NeedsRehash(hashedPassword []byte) bool {
+	return DefaultPasswordHasher.NeedsRehash(hashedPassword)
 }
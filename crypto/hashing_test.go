@@ -1,10 +1,13 @@
 package crypto
 
 import (
+	"encoding/base64"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestComparePasswordHash(t *testing.T) {
@@ -20,3 +23,92 @@ func TestGeneratePasswordHash(t *testing.T) {
 
 	assert.NotEqual(t, []byte("foo"), hash)
 }
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"Argon2id": &Argon2idHasher{Memory: 8 * 1024, Time: 1},
+		"Scrypt":   &ScryptHasher{LogN: 10},
+		"Bcrypt":   &BcryptHasher{Cost: bcrypt.MinCost},
+	}
+
+	for name, hasher := range hashers {
+		hasher := hasher
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash([]byte("correct horse"))
+			require.NoError(t, err)
+
+			ok, err := hasher.Verify(hash, []byte("correct horse"))
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = hasher.Verify(hash, []byte("battery staple"))
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.True(t, ComparePasswordHash(hash, []byte("correct horse")))
+			assert.False(t, ComparePasswordHash(hash, []byte("battery staple")))
+			assert.False(t, hasher.NeedsRehash(hash))
+
+			again, err := hasher.Hash([]byte("correct horse"))
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, again, "salts must be random")
+		})
+	}
+
+	t.Run("PHCFormat", func(t *testing.T) {
+		hash, err := hashers["Argon2id"].Hash([]byte("foo"))
+		require.NoError(t, err)
+		assert.Regexp(t, `^\$argon2id\$v=19\$m=8192,t=1,p=4\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, string(hash))
+
+		hash, err = hashers["Scrypt"].Hash([]byte("foo"))
+		require.NoError(t, err)
+		assert.Regexp(t, `^\$scrypt\$ln=10,r=8,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, string(hash))
+	})
+
+	t.Run("ForeignHash", func(t *testing.T) {
+		// Hashes written by other implementations use the same encoding
+		salt := []byte("somesalt")
+		key := argon2.IDKey([]byte("password"), salt, 2, 16*1024, 2, 24)
+		hash := "$argon2id$v=19$m=16384,t=2,p=2$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+		assert.True(t, ComparePasswordHash([]byte(hash), []byte("password")))
+		assert.False(t, ComparePasswordHash([]byte(hash), []byte("Password")))
+	})
+
+	t.Run("BcryptTooLong", func(t *testing.T) {
+		_, err := hashers["Bcrypt"].Hash(make([]byte, 73))
+		assert.Equal(t, ErrPasswordTooLong, err)
+	})
+
+	t.Run("BcryptVariants", func(t *testing.T) {
+		hash, err := hashers["Bcrypt"].Hash([]byte("foo"))
+		require.NoError(t, err)
+
+		for _, prefix := range []string{"$2b$", "$2y$"} {
+			assert.True(t, ComparePasswordHash(append([]byte(prefix), hash[4:]...), []byte("foo")))
+		}
+	})
+
+	t.Run("NeedsRehash", func(t *testing.T) {
+		weak, err := (&Argon2idHasher{Memory: 8 * 1024, Time: 1}).Hash([]byte("foo"))
+		require.NoError(t, err)
+
+		legacy, err := bcrypt.GenerateFromPassword([]byte("foo"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		current, err := GeneratePasswordHash([]byte("foo"))
+		require.NoError(t, err)
+
+		assert.True(t, NeedsRehash(weak))
+		assert.True(t, NeedsRehash(legacy))
+		assert.True(t, NeedsRehash([]byte("garbage")))
+		assert.False(t, NeedsRehash(current))
+		assert.True(t, (&BcryptHasher{}).NeedsRehash(legacy))
+		assert.False(t, (&BcryptHasher{Cost: bcrypt.MinCost}).NeedsRehash(legacy))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		for _, hash := range []string{"", "foo", "$argon2id$v=19$m=x$salt$hash", "$md5$abc", "$scrypt$ln=10,r=8,p=1$!!$!!"} {
+			assert.False(t, ComparePasswordHash([]byte(hash), []byte("foo")), hash)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Hybrid ciphertexts use the sealMagic followed by version 5, a random AES-256
+// key encrypted with RSA-OAEP-SHA256, and the message sealed with that key:
+//
+//	magic (2) | version (5) | encrypted key length (2) | encrypted key | Seal output
+//
+// Everything before the Seal output is part of its associated data.
+const sealVersionHybrid = 5
+
+// hybridLabel is the OAEP label, so that keys encrypted for other purposes
+// can't be passed off as ones of EncryptTo.
+var hybridLabel = []byte("eli hybrid v1")
+
+// EncryptTo encrypts plaintext of any size so that only the holder of the
+// private key of pub can decrypt it with DecryptWith.
+func EncryptTo(pub *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, hybridLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, sealHeaderSize+2, sealHeaderSize+2+len(encryptedKey))
+	copy(header, sealMagic)
+	header[len(sealMagic)] = sealVersionHybrid
+	binary.BigEndian.PutUint16(header[sealHeaderSize:], uint16(len(encryptedKey)))
+	header = append(header, encryptedKey...)
+
+	sealed, err := Seal(plaintext, key, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// DecryptWith decrypts a ciphertext of EncryptTo.
+func DecryptWith(priv *rsa.PrivateKey, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < sealHeaderSize+2 {
+		return nil, ErrTruncated
+	}
+	if !hasSealHeader(ciphertext) || ciphertext[len(sealMagic)] != sealVersionHybrid {
+		return nil, ErrUnsupportedVersion
+	}
+
+	headerSize := sealHeaderSize + 2 + int(binary.BigEndian.Uint16(ciphertext[sealHeaderSize:]))
+	if len(ciphertext) < headerSize {
+		return nil, ErrTruncated
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ciphertext[sealHeaderSize+2:headerSize], hybridLabel)
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return Open(ciphertext[headerSize:], key, ciphertext[:headerSize])
+}
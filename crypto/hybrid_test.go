@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptTo(t *testing.T) {
+	// The sender only has the public PEM
+	pub, err := ParseRsaPublicKeyFromPemStr(publicKey)
+	require.NoError(t, err)
+	priv, err := ParseRsaPrivateKeyFromPemStr(privateKey)
+	require.NoError(t, err)
+
+	ciphertext, err := EncryptTo(pub, []byte("database password"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "database password")
+
+	plaintext, err := DecryptWith(priv, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "database password", string(plaintext))
+
+	t.Run("Large", func(t *testing.T) {
+		large := make([]byte, 1<<20)
+		_, err := rand.Read(large)
+		require.NoError(t, err)
+
+		ciphertext, err := EncryptTo(pub, large)
+		require.NoError(t, err)
+
+		plaintext, err := DecryptWith(priv, ciphertext)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(large, plaintext))
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		_, err = DecryptWith(other, ciphertext)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		for _, i := range []int{sealHeaderSize + 2, sealHeaderSize + 100, len(ciphertext) - 20, len(ciphertext) - 1} {
+			tampered := append([]byte{}, ciphertext...)
+			tampered[i] ^= 1
+			_, err := DecryptWith(priv, tampered)
+			assert.Equal(t, ErrTampered, err, "byte %d", i)
+		}
+	})
+
+	t.Run("SwappedKey", func(t *testing.T) {
+		// A message sealed with a key that was encrypted with another label
+		key := make([]byte, 32)
+		encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+		require.NoError(t, err)
+
+		swapped := append(append(append([]byte{}, ciphertext[:sealHeaderSize+2]...), encryptedKey...), ciphertext[sealHeaderSize+2+len(encryptedKey):]...)
+		_, err = DecryptWith(priv, swapped)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		for _, n := range []int{0, 2, sealHeaderSize + 1, sealHeaderSize + 100, len(ciphertext) - 1} {
+			_, err := DecryptWith(priv, ciphertext[:n])
+			assert.Error(t, err, "length %d", n)
+		}
+	})
+
+	t.Run("NotHybrid", func(t *testing.T) {
+		sealed, err := Seal([]byte("secret"), key, nil)
+		require.NoError(t, err)
+		_, err = DecryptWith(priv, sealed)
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	})
+}
@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Keyring ciphertexts use the sealMagic followed by version 4 and the ID of
+// the key, followed by the output of Seal with that key:
+//
+//	magic (2) | version (4) | key ID length (1) | key ID | Seal output
+//
+// Everything before the Seal output is part of its associated data.
+const sealVersionKeyring = 4
+
+type KeyringOptions struct {
+	// Required, keys by ID. Keys should be 16, 24, or 32 bytes.
+	Keys map[string][]byte
+	// Required, the ID of the key new ciphertexts are encrypted with
+	Primary string
+	// ID of the key ciphertexts of Encrypt and Seal, which have no key ID, are
+	// decrypted with, optional
+	Legacy string
+}
+
+// Keyring encrypts with a primary key and decrypts with any of its keys, which
+// are identified by the key ID stored in each ciphertext. Keys can be rotated
+// without a flag day: make a new key primary, re-encrypt stored ciphertexts with
+// Reencrypt or ReencryptAll, then remove the old key. It is safe for concurrent use.
+type Keyring struct {
+	Options KeyringOptions
+
+	mu sync.RWMutex
+}
+
+// ErrUnknownKeyID is returned for ciphertexts encrypted with a key not in the keyring.
+var ErrUnknownKeyID = errors.New("crypto: unknown key ID")
+
+func NewKeyring(options KeyringOptions) *Keyring {
+	if _, ok := options.Keys[options.Primary]; !ok {
+		panic("crypto: the primary key must be in the keyring")
+	}
+	if _, ok := options.Keys[options.Legacy]; options.Legacy != "" && !ok {
+		panic("crypto: the legacy key must be in the keyring")
+	}
+	for id := range options.Keys {
+		if id == "" || len(id) > 255 {
+			panic("crypto: key IDs must have between 1 and 255 bytes")
+		}
+	}
+
+	keys := make(map[string][]byte, len(options.Keys))
+	for id, key := range options.Keys {
+		keys[id] = key
+	}
+	options.Keys = keys
+	return &Keyring{Options: options}
+}
+
+// Primary returns the ID of the primary key.
+func (k *Keyring) Primary() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.Options.Primary
+}
+
+// Rotate adds key and makes it the primary key. The previous primary key stays
+// in the keyring for decryption.
+func (k *Keyring) Rotate(keyID string, key []byte) error {
+	if keyID == "" || len(keyID) > 255 {
+		return errors.New("crypto: key IDs must have between 1 and 255 bytes")
+	}
+	if _, err := newGCM(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if existing, ok := k.Options.Keys[keyID]; ok && string(existing) != string(key) {
+		return errors.New("crypto: key " + keyID + " already exists")
+	}
+	k.Options.Keys[keyID] = key
+	k.Options.Primary = keyID
+	return nil
+}
+
+// Remove removes a key that is no longer needed for decryption. The primary key
+// can't be removed.
+func (k *Keyring) Remove(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if keyID == k.Options.Primary {
+		return errors.New("crypto: the primary key can't be removed")
+	}
+	if keyID == k.Options.Legacy {
+		k.Options.Legacy = ""
+	}
+	delete(k.Options.Keys, keyID)
+	return nil
+}
+
+// Encrypt seals plaintext with the primary key. additionalData is authenticated
+// but not encrypted, the same value must be passed to Decrypt.
+func (k *Keyring) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	k.mu.RLock()
+	keyID, key := k.Options.Primary, k.Options.Keys[k.Options.Primary]
+	k.mu.RUnlock()
+
+	header := make([]byte, 0, sealHeaderSize+1+len(keyID))
+	header = append(header, sealMagic...)
+	header = append(header, sealVersionKeyring, byte(len(keyID)))
+	header = append(header, keyID...)
+
+	sealed, err := Seal(plaintext, key, append(header[:len(header):len(header)], additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// Decrypt decrypts a ciphertext of Encrypt with the key it names. Ciphertexts
+// without a key ID are decrypted with the Legacy key if there is one.
+func (k *Keyring) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	plaintext, err := k.decrypt(ciphertext, additionalData)
+	if (err == ErrUnknownKeyID || err == ErrTruncated) && additionalData == nil && hasSealHeader(ciphertext) {
+		k.mu.RLock()
+		key, ok := k.Options.Keys[k.Options.Legacy]
+		k.mu.RUnlock()
+
+		// A legacy IV can start with the keyring header by chance
+		if ok {
+			if legacy, legacyErr := DecryptLegacy(ciphertext, key); legacyErr == nil {
+				return legacy, nil
+			}
+		}
+	}
+	return plaintext, err
+}
+
+func (k *Keyring) decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	keyID, legacy, err := k.keyID(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	key, ok := k.Options.Keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	if legacy {
+		if additionalData == nil {
+			return Decrypt(ciphertext, key)
+		}
+		return Open(ciphertext, key, additionalData)
+	}
+
+	headerSize := sealHeaderSize + 1 + len(keyID)
+	header := ciphertext[:headerSize:headerSize]
+	return Open(ciphertext[headerSize:], key, append(header, additionalData...))
+}
+
+// keyID returns the key ID of ciphertext, or the Legacy key ID if ciphertext
+// was written by Encrypt or Seal.
+func (k *Keyring) keyID(ciphertext []byte) (keyID string, legacy bool, err error) {
+	if !hasSealHeader(ciphertext) || ciphertext[len(sealMagic)] != sealVersionKeyring {
+		k.mu.RLock()
+		defer k.mu.RUnlock()
+
+		if k.Options.Legacy == "" {
+			return "", false, ErrUnknownKeyID
+		}
+		return k.Options.Legacy, true, nil
+	}
+
+	if len(ciphertext) < sealHeaderSize+1 || len(ciphertext) < sealHeaderSize+1+int(ciphertext[sealHeaderSize]) {
+		return "", false, ErrTruncated
+	}
+	return string(ciphertext[sealHeaderSize+1 : sealHeaderSize+1+int(ciphertext[sealHeaderSize])]), false, nil
+}
+
+// Reencrypt decrypts ciphertext and encrypts it again with the primary key,
+// unless it already is. It reports whether ciphertext was re-encrypted.
+func (k *Keyring) Reencrypt(ciphertext, additionalData []byte) ([]byte, bool, error) {
+	// Decrypt reports the errors of keyID, it also handles legacy ciphertexts keyID can't tell apart
+	keyID, legacy, err := k.keyID(ciphertext)
+	if err == nil && !legacy && keyID == k.Primary() {
+		return ciphertext, false, nil
+	}
+
+	plaintext, err := k.Decrypt(ciphertext, additionalData)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reencrypted, err := k.Encrypt(plaintext, additionalData)
+	if err != nil {
+		return nil, false, err
+	}
+	return reencrypted, true, nil
+}
+
+// CiphertextIterator walks stored ciphertexts for ReencryptAll.
+type CiphertextIterator interface {
+	// Next returns the next ciphertext and its associated data, or io.EOF after the last one
+	Next() (ciphertext, additionalData []byte, err error)
+	// Replace stores ciphertext in place of the one last returned by Next
+	Replace(ciphertext []byte) error
+}
+
+// ReencryptAll re-encrypts every ciphertext of it that isn't encrypted with the
+// primary key and returns how many were re-encrypted. It stops at the first
+// error, after which it can be run again as ciphertexts already re-encrypted are skipped.
+func (k *Keyring) ReencryptAll(it CiphertextIterator) (int, error) {
+	count := 0
+	for {
+		ciphertext, additionalData, err := it.Next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+
+		reencrypted, changed, err := k.Reencrypt(ciphertext, additionalData)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+
+		if err := it.Replace(reencrypted); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
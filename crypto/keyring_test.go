@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key2 = []byte("0123456789abcdef0123456789abcdef")
+	key3 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// sliceIterator is a CiphertextIterator over a slice, like a table of rows.
+type sliceIterator struct {
+	rows [][]byte
+	i    int
+	fail error
+}
+
+func (s *sliceIterator) Next() ([]byte, []byte, error) {
+	if s.i == len(s.rows) {
+		return nil, nil, io.EOF
+	}
+	s.i++
+	return s.rows[s.i-1], nil, nil
+}
+
+func (s *sliceIterator) Replace(ciphertext []byte) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.rows[s.i-1] = ciphertext
+	return nil
+}
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key}, Primary: "2019"})
+
+	ciphertext, err := k.Encrypt([]byte("secret"), []byte("user:42"))
+	require.NoError(t, err)
+
+	plaintext, err := k.Decrypt(ciphertext, []byte("user:42"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	t.Run("Rotate", func(t *testing.T) {
+		k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key}, Primary: "2019"})
+		old, err := k.Encrypt([]byte("secret"), nil)
+		require.NoError(t, err)
+
+		require.NoError(t, k.Rotate("2020", key2))
+		assert.Equal(t, "2020", k.Primary())
+
+		current, err := k.Encrypt([]byte("secret"), nil)
+		require.NoError(t, err)
+
+		for _, c := range [][]byte{old, current} {
+			plaintext, err := k.Decrypt(c, nil)
+			require.NoError(t, err)
+			assert.Equal(t, "secret", string(plaintext))
+		}
+
+		assert.Error(t, k.Remove("2020"))
+		require.NoError(t, k.Remove("2019"))
+		_, err = k.Decrypt(old, nil)
+		assert.Equal(t, ErrUnknownKeyID, err)
+
+		assert.Error(t, k.Rotate("2020", key3), "key IDs can't be reused for other keys")
+		assert.Error(t, k.Rotate("2021", []byte("short")))
+	})
+
+	t.Run("KeyIDAuthenticated", func(t *testing.T) {
+		k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key, "2018": key}, Primary: "2019"})
+		ciphertext, err := k.Encrypt([]byte("secret"), nil)
+		require.NoError(t, err)
+
+		// 2019 becomes 2018, which has the same key
+		ciphertext[sealHeaderSize+1+3] = '8'
+		_, err = k.Decrypt(ciphertext, nil)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("WrongAdditionalData", func(t *testing.T) {
+		_, err := k.Decrypt(ciphertext, []byte("user:43"))
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		for n := 0; n < len(ciphertext); n++ {
+			_, err := k.Decrypt(ciphertext[:n], []byte("user:42"))
+			assert.Error(t, err, "length %d", n)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		encrypted, err := Encrypt([]byte("secret"), key)
+		require.NoError(t, err)
+		sealed, err := Seal([]byte("secret"), key, []byte("user:42"))
+		require.NoError(t, err)
+		cfb := encryptCFB(t, []byte("secret"), key)
+
+		_, err = k.Decrypt(encrypted, nil)
+		assert.Equal(t, ErrUnknownKeyID, err)
+
+		k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"legacy": key, "2020": key2}, Primary: "2020", Legacy: "legacy"})
+		for _, c := range [][]byte{encrypted, cfb} {
+			plaintext, err := k.Decrypt(c, nil)
+			require.NoError(t, err)
+			assert.Equal(t, "secret", string(plaintext))
+		}
+
+		plaintext, err := k.Decrypt(sealed, []byte("user:42"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(plaintext))
+
+		// Legacy IVs can start with the header of any version, the keyring's included
+		for _, version := range []byte{sealVersionKeyring, sealVersionHybrid} {
+			cfb := encryptCFBWithIVPrefix(t, []byte("secret"), key, append(append([]byte{}, sealMagic...), version))
+			plaintext, err := k.Decrypt(cfb, nil)
+			require.NoError(t, err, "version %d", version)
+			assert.Equal(t, "secret", string(plaintext))
+
+			reencrypted, changed, err := k.Reencrypt(cfb, nil)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			plaintext, err = k.Decrypt(reencrypted, nil)
+			require.NoError(t, err)
+			assert.Equal(t, "secret", string(plaintext))
+		}
+	})
+
+	t.Run("Reencrypt", func(t *testing.T) {
+		k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key}, Primary: "2019"})
+		old, err := k.Encrypt([]byte("secret"), []byte("user:42"))
+		require.NoError(t, err)
+		require.NoError(t, k.Rotate("2020", key2))
+
+		reencrypted, changed, err := k.Reencrypt(old, []byte("user:42"))
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		require.NoError(t, k.Remove("2019"))
+		plaintext, err := k.Decrypt(reencrypted, []byte("user:42"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(plaintext))
+
+		again, changed, err := k.Reencrypt(reencrypted, []byte("user:42"))
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, reencrypted, again)
+	})
+
+	t.Run("ReencryptAll", func(t *testing.T) {
+		legacy, err := Encrypt([]byte("row 0"), key)
+		require.NoError(t, err)
+
+		k := NewKeyring(KeyringOptions{Keys: map[string][]byte{"legacy": key, "2019": key2}, Primary: "2019", Legacy: "legacy"})
+		rows := [][]byte{legacy}
+		for _, row := range []string{"row 1", "row 2"} {
+			c, err := k.Encrypt([]byte(row), nil)
+			require.NoError(t, err)
+			rows = append(rows, c)
+		}
+
+		require.NoError(t, k.Rotate("2020", key3))
+		current, err := k.Encrypt([]byte("row 3"), nil)
+		require.NoError(t, err)
+		rows = append(rows, current)
+
+		n, err := k.ReencryptAll(&sliceIterator{rows: rows, fail: errors.New("database is down")})
+		assert.EqualError(t, err, "database is down")
+		assert.Equal(t, 0, n)
+
+		n, err = k.ReencryptAll(&sliceIterator{rows: rows})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, current, rows[3])
+
+		require.NoError(t, k.Remove("legacy"))
+		require.NoError(t, k.Remove("2019"))
+		for i, row := range []string{"row 0", "row 1", "row 2", "row 3"} {
+			plaintext, err := k.Decrypt(rows[i], nil)
+			require.NoError(t, err)
+			assert.Equal(t, row, string(plaintext))
+		}
+
+		n, err = k.ReencryptAll(&sliceIterator{rows: rows})
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		assert.Panics(t, func() { NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key}, Primary: "2020"}) })
+		assert.Panics(t, func() {
+			NewKeyring(KeyringOptions{Keys: map[string][]byte{"2019": key}, Primary: "2019", Legacy: "2018"})
+		})
+	})
+}
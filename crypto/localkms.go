@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LocalKeyManager is a KeyManager keeping its key-encryption keys in a JSON
+// file, meant for development and tests in place of a cloud KMS. It is safe
+// for concurrent use, but not for use by several processes at once.
+type LocalKeyManager struct {
+	path string
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// localKeyFile is the format of the file of a LocalKeyManager. Keys are base64
+// encoded by encoding/json.
+type localKeyFile struct {
+	Keys map[string][]byte `json:"keys"`
+}
+
+// OpenLocalKeyManager reads the keys in the file at path. The file doesn't need
+// to exist until the first key is created.
+func OpenLocalKeyManager(path string) (*LocalKeyManager, error) {
+	m := &LocalKeyManager{path: path, keys: map[string][]byte{}}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file localKeyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+	for id, key := range file.Keys {
+		if len(key) != 32 {
+			return nil, errors.New("crypto: key " + id + " is not 32 bytes")
+		}
+		m.keys[id] = key
+	}
+	return m, nil
+}
+
+// CreateKey generates a new key-encryption key and saves it. It fails if keyID
+// already exists, so that data keys wrapped by that key stay readable.
+func (m *LocalKeyManager) CreateKey(keyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.keys[keyID]; ok {
+		return errors.New("crypto: key " + keyID + " already exists")
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	m.keys[keyID] = key
+	if err := m.save(); err != nil {
+		delete(m.keys, keyID)
+		return err
+	}
+	return nil
+}
+
+// save writes the keys to a temporary file that replaces the file at path, so
+// that the file is never left half written.
+func (m *LocalKeyManager) save() error {
+	raw, err := json.MarshalIndent(localKeyFile{Keys: m.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), m.path)
+}
+
+func (m *LocalKeyManager) key(keyID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[keyID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// WrapKey seals dataKey with the key keyID, binding it to the key ID.
+func (m *LocalKeyManager) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, err := m.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(dataKey, key, []byte(keyID))
+}
+
+func (m *LocalKeyManager) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	key, err := m.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Open(wrappedKey, key, []byte(keyID))
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalKeyManager(t *testing.T) {
+	ctx := context.Background()
+	m := newTestKeyManager(t, "kek-1")
+
+	wrapped, err := m.WrapKey(ctx, "kek-1", []byte("data key"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(wrapped), "data key")
+
+	dataKey, err := m.UnwrapKey(ctx, "kek-1", wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "data key", string(dataKey))
+
+	t.Run("Reopen", func(t *testing.T) {
+		info, err := os.Stat(m.path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		reopened, err := OpenLocalKeyManager(m.path)
+		require.NoError(t, err)
+
+		dataKey, err := reopened.UnwrapKey(ctx, "kek-1", wrapped)
+		require.NoError(t, err)
+		assert.Equal(t, "data key", string(dataKey))
+	})
+
+	t.Run("KeyIDBound", func(t *testing.T) {
+		require.NoError(t, m.CreateKey("kek-2"))
+		_, err := m.UnwrapKey(ctx, "kek-2", wrapped)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		_, err := m.WrapKey(ctx, "kek-3", []byte("data key"))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		_, err = m.UnwrapKey(ctx, "kek-3", wrapped)
+		assert.Equal(t, ErrKeyNotFound, err)
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		assert.Error(t, m.CreateKey("kek-1"))
+
+		dataKey, err := m.UnwrapKey(ctx, "kek-1", wrapped)
+		require.NoError(t, err)
+		assert.Equal(t, "data key", string(dataKey))
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "eli-kms")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		for _, content := range []string{"not json", `{"keys": {"kek-1": "c2hvcnQ="}}`} {
+			require.NoError(t, ioutil.WriteFile(f.Name(), []byte(content), 0600))
+			_, err := OpenLocalKeyManager(f.Name())
+			assert.Error(t, err, content)
+		}
+	})
+}
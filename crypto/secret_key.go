@@ -1,38 +1,113 @@
 package crypto
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
 )
 
-var src = rand.NewSource(time.Now().UnixNano())
-
+// SecretKeyLength is the length of the keys GenerateSecretKey returns.
 const SecretKeyLength = 32
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// Alphabet is the set of characters a secret is made of.
+type Alphabet string
+
 const (
-	letterIdxBits = 6                    // 6 bits to represent a letter index
-	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
+	AlphabetHex          Alphabet = "0123456789abcdef"
+	AlphabetBase32       Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	AlphabetBase64URL    Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	AlphabetAlphanumeric Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	AlphabetNumeric      Alphabet = "0123456789"
 )
 
-// From https://stackoverflow.com/a/31832326
-// This is NOT goroutine safe, if it ever needs to be, change src to the default rand source.
-func GenerateSecretKey() string {
-	n := SecretKeyLength
-	b := make([]byte, SecretKeyLength)
+var (
+	// ErrInvalidAlphabet is returned for alphabets with fewer than 2 characters, repeated
+	// characters or characters outside of ASCII.
+	ErrInvalidAlphabet = errors.New("crypto: alphabet must have at least 2 distinct ASCII characters")
+	// ErrInsufficientEntropy is returned when a secret of the requested length
+	// can't have the requested entropy.
+	ErrInsufficientEntropy = errors.New("crypto: secret length is too short for the requested entropy")
+)
+
+// valid reports whether a is made of at least 2 distinct ASCII characters.
+// Repeated characters would be more likely than the others, and multibyte
+// ones would be split.
+func (a Alphabet) valid() bool {
+	if len(a) < 2 {
+		return false
+	}
+
+	var seen [128]bool
+	for i := 0; i < len(a); i++ {
+		if a[i] >= 128 || seen[a[i]] {
+			return false
+		}
+		seen[a[i]] = true
+	}
+	return true
+}
+
+// EntropyBits returns the entropy of a secret of length random characters of a.
+func (a Alphabet) EntropyBits(length int) float64 {
+	return float64(length) * math.Log2(float64(len(a)))
+}
 
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+type SecretOptions struct {
+	// Defaults to SecretKeyLength, or to the shortest length with MinEntropyBits if that is set
+	Length int
+	// Defaults to AlphabetAlphanumeric
+	Alphabet Alphabet
+	// Minimum entropy of the secret in bits, optional
+	MinEntropyBits int
+}
+
+// GenerateSecret returns a secret of random characters read from crypto/rand.
+// Every character of the alphabet is equally likely. It is safe for concurrent use.
+func GenerateSecret(options SecretOptions) (string, error) {
+	alphabet := options.Alphabet
+	if alphabet == "" {
+		alphabet = AlphabetAlphanumeric
+	}
+	if !alphabet.valid() {
+		return "", ErrInvalidAlphabet
+	}
+
+	length := options.Length
+	if length == 0 {
+		length = SecretKeyLength
+		if options.MinEntropyBits > 0 {
+			length = int(math.Ceil(float64(options.MinEntropyBits) / alphabet.EntropyBits(1)))
+		}
+	}
+	if alphabet.EntropyBits(length) < float64(options.MinEntropyBits) {
+		return "", ErrInsufficientEntropy
+	}
+
+	// Bytes at or above limit are discarded, taking the others modulo the size
+	// of the alphabet would favour its first characters otherwise
+	limit := 256 - 256%len(alphabet)
+	secret := make([]byte, 0, length)
+	buf := make([]byte, length+length/4+8)
+	for len(secret) < length {
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			return "", err
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		for _, b := range buf {
+			if int(b) < limit && len(secret) < length {
+				secret = append(secret, alphabet[int(b)%len(alphabet)])
+			}
 		}
-		cache >>= letterIdxBits
-		remain--
 	}
+	return string(secret), nil
+}
 
-	return string(b)
+// GenerateSecretKey returns SecretKeyLength random alphanumeric characters. It
+// is safe for concurrent use and panics if crypto/rand fails.
+func GenerateSecretKey() string {
+	secret, err := GenerateSecret(SecretOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return secret
 }
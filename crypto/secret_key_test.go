@@ -1,9 +1,13 @@
 package crypto
 
 import (
+	"math"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGenerateSecretKey(t *testing.T) {
@@ -12,4 +16,110 @@ func TestGenerateSecretKey(t *testing.T) {
 
 	key2 := GenerateSecretKey()
 	assert.NotEqual(t, key2, key1)
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var mu sync.Mutex
+		seen := map[string]bool{}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := GenerateSecretKey()
+
+				mu.Lock()
+				defer mu.Unlock()
+				assert.False(t, seen[key])
+				seen[key] = true
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, seen, 50)
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	alphabets := []Alphabet{AlphabetHex, AlphabetBase32, AlphabetBase64URL, AlphabetAlphanumeric, AlphabetNumeric}
+	for _, alphabet := range alphabets {
+		secret, err := GenerateSecret(SecretOptions{Length: 40, Alphabet: alphabet})
+		require.NoError(t, err)
+		assert.Len(t, secret, 40)
+		for _, c := range secret {
+			assert.True(t, strings.ContainsRune(string(alphabet), c), string(alphabet))
+		}
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		secret, err := GenerateSecret(SecretOptions{})
+		require.NoError(t, err)
+		assert.Len(t, secret, SecretKeyLength)
+		assert.Regexp(t, `^[a-zA-Z0-9]+$`, secret)
+	})
+
+	t.Run("MinEntropyBits", func(t *testing.T) {
+		secret, err := GenerateSecret(SecretOptions{Alphabet: AlphabetHex, MinEntropyBits: 128})
+		require.NoError(t, err)
+		assert.Len(t, secret, 32)
+
+		secret, err = GenerateSecret(SecretOptions{Alphabet: AlphabetNumeric, MinEntropyBits: 20})
+		require.NoError(t, err)
+		assert.Len(t, secret, 7)
+
+		_, err = GenerateSecret(SecretOptions{Length: 6, Alphabet: AlphabetNumeric, MinEntropyBits: 20})
+		assert.Equal(t, ErrInsufficientEntropy, err)
+	})
+
+	t.Run("InvalidAlphabet", func(t *testing.T) {
+		_, err := GenerateSecret(SecretOptions{Alphabet: "a"})
+		assert.Equal(t, ErrInvalidAlphabet, err)
+
+		_, err = GenerateSecret(SecretOptions{Alphabet: Alphabet(strings.Repeat("a", 257))})
+		assert.Equal(t, ErrInvalidAlphabet, err)
+
+		// Duplicates would make their character more likely
+		_, err = GenerateSecret(SecretOptions{Alphabet: "0123456789abcdefa"})
+		assert.Equal(t, ErrInvalidAlphabet, err)
+
+		// Multibyte characters would be split into invalid UTF-8
+		_, err = GenerateSecret(SecretOptions{Alphabet: "abcdéf"})
+		assert.Equal(t, ErrInvalidAlphabet, err)
+		_, err = GenerateSecret(SecretOptions{Alphabet: "\xff\xfe"})
+		assert.Equal(t, ErrInvalidAlphabet, err)
+
+		for _, alphabet := range []Alphabet{AlphabetHex, AlphabetBase32, AlphabetBase64URL, AlphabetAlphanumeric, AlphabetNumeric} {
+			_, err = GenerateSecret(SecretOptions{Alphabet: alphabet})
+			assert.NoError(t, err, alphabet)
+		}
+	})
+
+	t.Run("Distribution", func(t *testing.T) {
+		// Alphabets whose size doesn't divide 256 are biased towards their first
+		// characters by a naive modulo, which a chi-squared test catches easily
+		for _, alphabet := range []Alphabet{AlphabetNumeric, AlphabetAlphanumeric, AlphabetBase32} {
+			const samples = 400000
+			counts := make(map[rune]int, len(alphabet))
+			for n := 0; n < samples; {
+				secret, err := GenerateSecret(SecretOptions{Length: 1000, Alphabet: alphabet})
+				require.NoError(t, err)
+				for _, c := range secret {
+					counts[c]++
+				}
+				n += len(secret)
+			}
+			require.Len(t, counts, len(alphabet))
+
+			expected := float64(samples) / float64(len(alphabet))
+			chi2 := 0.0
+			for _, count := range counts {
+				d := float64(count) - expected
+				chi2 += d * d / expected
+			}
+
+			// Eight standard deviations above the mean of the chi-squared
+			// distribution, which a modulo bias exceeds several times over
+			df := float64(len(alphabet) - 1)
+			assert.True(t, chi2 < df+8*math.Sqrt(2*df), "%s: chi2 = %.1f", alphabet, chi2)
+		}
+	})
 }
@@ -0,0 +1,340 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedKey is returned for keys other than RSA, ECDSA P-256 or P-384 and Ed25519 keys.
+	ErrUnsupportedKey = errors.New("crypto: unsupported key type")
+	// ErrInvalidSignature is returned for malformed signatures and signatures that don't match the message.
+	ErrInvalidSignature = errors.New("crypto: invalid signature")
+	// ErrSignatureKeyMismatch is returned when a signature was made by another key
+	// than the one it is verified with.
+	ErrSignatureKeyMismatch = errors.New("crypto: signature was made with another key")
+	// ErrSignatureNotStreamable is returned by NewVerifier for EdDSA signatures,
+	// which sign the whole message and can only be checked with Verify.
+	ErrSignatureNotStreamable = errors.New("crypto: EdDSA signatures can't be verified in parts")
+)
+
+// SignatureAlgorithm identifies how a DetachedSignature was made, using the
+// names of JSON Web Algorithms, and of RFC 8032 for Ed25519ph.
+type SignatureAlgorithm string
+
+const (
+	// RSA-PSS with SHA-256 and a salt as long as the hash
+	SignaturePS256 SignatureAlgorithm = "PS256"
+	// ECDSA with P-256 and SHA-256
+	SignatureES256 SignatureAlgorithm = "ES256"
+	// ECDSA with P-384 and SHA-384
+	SignatureES384 SignatureAlgorithm = "ES384"
+	// Ed25519 of the message, made by Sign for Ed25519 keys
+	SignatureEdDSA SignatureAlgorithm = "EdDSA"
+	// Ed25519 of the SHA-512 hash of the message, made by Signer for Ed25519 keys
+	SignatureEd25519ph SignatureAlgorithm = "Ed25519ph"
+)
+
+var signatureHashes = map[SignatureAlgorithm]stdcrypto.Hash{
+	SignaturePS256: stdcrypto.SHA256,
+	SignatureES256: stdcrypto.SHA256,
+	SignatureES384: stdcrypto.SHA384,
+	// Ed25519 hashes the message itself
+	SignatureEdDSA:     0,
+	SignatureEd25519ph: stdcrypto.SHA512,
+}
+
+// newHash returns the hash the message is written to.
+func (a SignatureAlgorithm) newHash() hash.Hash {
+	switch signatureHashes[a] {
+	case stdcrypto.SHA256:
+		return sha256.New()
+	case stdcrypto.SHA384:
+		return sha512.New384()
+	default:
+		return sha512.New()
+	}
+}
+
+// signatureAlgorithm returns the algorithm a Signer uses with pub, Sign uses
+// SignatureEdDSA instead of SignatureEd25519ph.
+func signatureAlgorithm(pub stdcrypto.PublicKey) (SignatureAlgorithm, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return SignaturePS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return SignatureES256, nil
+		case elliptic.P384():
+			return SignatureES384, nil
+		}
+	case ed25519.PublicKey:
+		if len(pub) == ed25519.PublicKeySize {
+			return SignatureEd25519ph, nil
+		}
+	}
+	return "", ErrUnsupportedKey
+}
+
+// KeyFingerprint returns the unpadded base64url encoding of the SHA-256 hash
+// of the PKIX encoding of pub.
+func KeyFingerprint(pub stdcrypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", ErrUnsupportedKey
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// DetachedSignature is a signature stored apart from the message it signs. Its
+// compact encoding is the algorithm, the fingerprint of the signing key and the
+// unpadded base64url encoded signature, separated by dots:
+//
+//	ES256.<key fingerprint>.<signature>
+//
+// ECDSA signatures are the fixed size concatenation of r and s.
+type DetachedSignature struct {
+	Algorithm      SignatureAlgorithm
+	KeyFingerprint string
+	Signature      []byte
+}
+
+// ParseDetachedSignature decodes the compact encoding of a signature, for
+// example to look up the verification key by its fingerprint.
+func ParseDetachedSignature(signature []byte) (*DetachedSignature, error) {
+	parts := strings.Split(string(signature), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSignature
+	}
+
+	alg := SignatureAlgorithm(parts[0])
+	if _, ok := signatureHashes[alg]; !ok {
+		return nil, ErrInvalidSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || parts[1] == "" || len(sig) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	return &DetachedSignature{Algorithm: alg, KeyFingerprint: parts[1], Signature: sig}, nil
+}
+
+// Bytes returns the compact encoding of s.
+func (s *DetachedSignature) Bytes() []byte {
+	return []byte(string(s.Algorithm) + "." + s.KeyFingerprint + "." + base64.RawURLEncoding.EncodeToString(s.Signature))
+}
+
+// Signer signs a message written to it in parts, like the body of a request
+// or a large file. Ed25519 keys make Ed25519ph signatures, so that the message
+// is hashed as it is written too.
+type Signer struct {
+	key         stdcrypto.Signer
+	alg         SignatureAlgorithm
+	fingerprint string
+	hash        hash.Hash
+}
+
+// NewSigner returns a Signer for an RSA, ECDSA P-256 or P-384, or Ed25519
+// private key. Keys held elsewhere, like in a KMS, work too.
+func NewSigner(key stdcrypto.Signer) (*Signer, error) {
+	alg, err := signatureAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := KeyFingerprint(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key: key, alg: alg, fingerprint: fingerprint, hash: alg.newHash()}, nil
+}
+
+// Write adds p to the message. It never returns an error.
+func (s *Signer) Write(p []byte) (int, error) {
+	return s.hash.Write(p)
+}
+
+// Sign returns the compact encoding of the signature of everything written so far.
+func (s *Signer) Sign() ([]byte, error) {
+	digest := s.hash.Sum(nil)
+
+	var opts stdcrypto.SignerOpts = signatureHashes[s.alg]
+	switch s.alg {
+	case SignaturePS256:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: stdcrypto.SHA256}
+	case SignatureEd25519ph:
+		opts = &ed25519.Options{Hash: stdcrypto.SHA512}
+	}
+
+	sig, err := s.key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.alg == SignatureES256 || s.alg == SignatureES384 {
+		if sig, err = ecdsaCompact(s.key.Public().(*ecdsa.PublicKey), sig); err != nil {
+			return nil, err
+		}
+	}
+	return (&DetachedSignature{Algorithm: s.alg, KeyFingerprint: s.fingerprint, Signature: sig}).Bytes(), nil
+}
+
+// ecdsaSignature is the ASN.1 encoding of ECDSA signatures of crypto.Signer.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ecdsaCompact converts an ASN.1 encoded ECDSA signature to r and s padded to the size of the curve.
+func ecdsaCompact(pub *ecdsa.PublicKey, der []byte) ([]byte, error) {
+	var sig ecdsaSignature
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	r, s := sig.R.Bytes(), sig.S.Bytes()
+	if len(r) > size || len(s) > size {
+		return nil, ErrInvalidSignature
+	}
+
+	out := make([]byte, 2*size)
+	copy(out[size-len(r):size], r)
+	copy(out[2*size-len(s):], s)
+	return out, nil
+}
+
+// Verifier verifies a signature of a message written to it in parts.
+type Verifier struct {
+	pub       stdcrypto.PublicKey
+	signature *DetachedSignature
+	hash      hash.Hash
+}
+
+// NewVerifier returns a Verifier of signature. It returns ErrSignatureKeyMismatch
+// right away if signature wasn't made with the private key of pub, and
+// ErrSignatureNotStreamable for EdDSA signatures.
+func NewVerifier(pub stdcrypto.PublicKey, signature []byte) (*Verifier, error) {
+	sig, err := parseSignatureOf(pub, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if sig.Algorithm == SignatureEdDSA {
+		return nil, ErrSignatureNotStreamable
+	}
+	return &Verifier{pub: pub, signature: sig, hash: sig.Algorithm.newHash()}, nil
+}
+
+// parseSignatureOf parses signature and checks that it was made with the
+// private key of pub.
+func parseSignatureOf(pub stdcrypto.PublicKey, signature []byte) (*DetachedSignature, error) {
+	alg, err := signatureAlgorithm(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := KeyFingerprint(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := ParseDetachedSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if alg == SignatureEd25519ph && sig.Algorithm == SignatureEdDSA {
+		alg = SignatureEdDSA
+	}
+	if sig.Algorithm != alg || sig.KeyFingerprint != fingerprint {
+		return nil, ErrSignatureKeyMismatch
+	}
+	return sig, nil
+}
+
+// Write adds p to the message. It never returns an error.
+func (v *Verifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+// Verify returns ErrInvalidSignature unless the signature matches everything written so far.
+func (v *Verifier) Verify() error {
+	digest := v.hash.Sum(nil)
+	sig := v.signature.Signature
+
+	valid := false
+	switch pub := v.pub.(type) {
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: stdcrypto.SHA256}
+		valid = rsa.VerifyPSS(pub, stdcrypto.SHA256, digest, sig, opts) == nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(pub, digest, r, s)
+		}
+	case ed25519.PublicKey:
+		valid = ed25519.VerifyWithOptions(pub, digest, sig, &ed25519.Options{Hash: stdcrypto.SHA512}) == nil
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns the compact encoding of a detached signature of message.
+// Ed25519 keys make EdDSA signatures of the message itself.
+func Sign(key stdcrypto.Signer, message []byte) ([]byte, error) {
+	s, err := NewSigner(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.alg != SignatureEd25519ph {
+		s.Write(message)
+		return s.Sign()
+	}
+
+	sig, err := key.Sign(rand.Reader, message, stdcrypto.Hash(0))
+	if err != nil {
+		return nil, err
+	}
+	return (&DetachedSignature{Algorithm: SignatureEdDSA, KeyFingerprint: s.fingerprint, Signature: sig}).Bytes(), nil
+}
+
+// Verify returns nil if signature is a signature of message made with the
+// private key of pub. It verifies signatures of Sign as well as of Signer.
+func Verify(pub stdcrypto.PublicKey, message, signature []byte) error {
+	sig, err := parseSignatureOf(pub, signature)
+	if err != nil {
+		return err
+	}
+
+	if sig.Algorithm == SignatureEdDSA {
+		if !ed25519.Verify(pub.(ed25519.PublicKey), message, sig.Signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	v := &Verifier{pub: pub, signature: sig, hash: sig.Algorithm.newHash()}
+	v.Write(message)
+	return v.Verify()
+}
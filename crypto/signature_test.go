@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keys := map[SignatureAlgorithm]stdcrypto.Signer{
+		SignaturePS256: rsaKey,
+		SignatureES256: p256Key,
+		SignatureES384: p384Key,
+		SignatureEdDSA: edKey,
+	}
+	message := []byte(`{"event":"invoice.paid","id":42}`)
+
+	for alg, key := range keys {
+		alg, key := alg, key
+		t.Run(string(alg), func(t *testing.T) {
+			signature, err := Sign(key, message)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(signature), string(alg)+"."), string(signature))
+
+			parsed, err := ParseDetachedSignature(signature)
+			require.NoError(t, err)
+			fingerprint, err := KeyFingerprint(key.Public())
+			require.NoError(t, err)
+			assert.Equal(t, alg, parsed.Algorithm)
+			assert.Equal(t, fingerprint, parsed.KeyFingerprint)
+			assert.Equal(t, signature, parsed.Bytes())
+
+			assert.NoError(t, Verify(key.Public(), message, signature))
+			assert.Equal(t, ErrInvalidSignature, Verify(key.Public(), []byte(`{"event":"invoice.paid","id":43}`), signature))
+
+			tampered := append([]byte{}, parsed.Signature...)
+			tampered[len(tampered)/2] ^= 1
+			assert.Equal(t, ErrInvalidSignature, Verify(key.Public(), message, (&DetachedSignature{Algorithm: alg, KeyFingerprint: fingerprint, Signature: tampered}).Bytes()))
+
+			t.Run("Streaming", func(t *testing.T) {
+				s, err := NewSigner(key)
+				require.NoError(t, err)
+				_, err = io.Copy(s, bytes.NewReader(message))
+				require.NoError(t, err)
+				streamed, err := s.Sign()
+				require.NoError(t, err)
+				assert.NoError(t, Verify(key.Public(), message, streamed))
+
+				v, err := NewVerifier(key.Public(), streamed)
+				require.NoError(t, err)
+				for _, part := range bytes.SplitAfter(message, []byte(",")) {
+					v.Write(part)
+				}
+				assert.NoError(t, v.Verify())
+
+				if alg == SignatureEdDSA {
+					// Streamed Ed25519 signatures sign the hash of the message
+					assert.True(t, strings.HasPrefix(string(streamed), string(SignatureEd25519ph)+"."), string(streamed))
+					_, err = NewVerifier(key.Public(), signature)
+					assert.Equal(t, ErrSignatureNotStreamable, err)
+					return
+				}
+
+				// Streamed and one-shot signatures are interchangeable
+				v, err = NewVerifier(key.Public(), signature)
+				require.NoError(t, err)
+				v.Write(message)
+				assert.NoError(t, v.Verify())
+			})
+		})
+	}
+
+	t.Run("KeyMismatch", func(t *testing.T) {
+		signature, err := Sign(p256Key, message)
+		require.NoError(t, err)
+
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		assert.Equal(t, ErrSignatureKeyMismatch, Verify(&other.PublicKey, message, signature))
+		assert.Equal(t, ErrSignatureKeyMismatch, Verify(&p384Key.PublicKey, message, signature))
+
+		// A signature claiming another algorithm for the same key
+		parsed, err := ParseDetachedSignature(signature)
+		require.NoError(t, err)
+		parsed.Algorithm = SignatureES384
+		assert.Equal(t, ErrSignatureKeyMismatch, Verify(&p256Key.PublicKey, message, parsed.Bytes()))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		fingerprint, err := KeyFingerprint(edKey.Public())
+		require.NoError(t, err)
+
+		for _, signature := range []string{
+			"",
+			"EdDSA." + fingerprint,
+			"EdDSA." + fingerprint + ".!!",
+			"EdDSA." + fingerprint + ".",
+			"HS256." + fingerprint + ".c2ln",
+			"EdDSA." + fingerprint + ".c2ln.c2ln",
+		} {
+			assert.Equal(t, ErrInvalidSignature, Verify(edKey.Public(), message, []byte(signature)), signature)
+		}
+
+		// A signature of the wrong length
+		assert.Equal(t, ErrInvalidSignature, Verify(edKey.Public(), message, []byte("EdDSA."+fingerprint+".c2ln")))
+	})
+
+	t.Run("KnownAnswer", func(t *testing.T) {
+		// TEST 1 of RFC 8032 section 7.1, an empty message
+		seed, err := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+		require.NoError(t, err)
+		key := ed25519.NewKeyFromSeed(seed)
+		assert.Equal(t, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a", hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+
+		const expected = "EdDSA.BuP9j9opu2CrWVV95h7bCuzbIxE0vjDnW0Vfjht5L6k.5VZDAMNgrHKQhuLMgG6CioSHfx645dl02HPgZSJJAVVfuIIVkKM7rMYeOXAc-bRr0lv18FlbviRlUUFDjnoQCw"
+		signature, err := Sign(key, nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(signature))
+
+		parsed, err := ParseDetachedSignature(signature)
+		require.NoError(t, err)
+		assert.Equal(t, "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b", hex.EncodeToString(parsed.Signature))
+		assert.NoError(t, Verify(key.Public(), nil, []byte(expected)))
+	})
+
+	t.Run("KnownAnswerEd25519ph", func(t *testing.T) {
+		// TEST abc of RFC 8032 section 7.3
+		seed, err := hex.DecodeString("833fe62409237b9d62ec77587520911e9a759cec1d19755b7da901b96dca3d42")
+		require.NoError(t, err)
+		key := ed25519.NewKeyFromSeed(seed)
+		assert.Equal(t, "ec172b93ad5e563bf4932c70e1245034c35467ef2efd4d64ebf819683467e2bf", hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+
+		s, err := NewSigner(key)
+		require.NoError(t, err)
+		s.Write([]byte("a"))
+		s.Write([]byte("bc"))
+		signature, err := s.Sign()
+		require.NoError(t, err)
+
+		parsed, err := ParseDetachedSignature(signature)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureEd25519ph, parsed.Algorithm)
+		assert.Equal(t, "98a70222f0b8121aa9d30f813d683f809e462b469c7ff87639499bb94e6dae4131f85042463c2a355a2003d062adf5aaa10b8c61e636062aaad11c2a26083406", hex.EncodeToString(parsed.Signature))
+		assert.NoError(t, Verify(key.Public(), []byte("abc"), signature))
+
+		// The same bytes are no EdDSA signature of the message
+		parsed.Algorithm = SignatureEdDSA
+		assert.Equal(t, ErrInvalidSignature, Verify(key.Public(), []byte("abc"), parsed.Bytes()))
+	})
+
+	t.Run("UnsupportedKey", func(t *testing.T) {
+		p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		require.NoError(t, err)
+
+		_, err = Sign(p224Key, message)
+		assert.Equal(t, ErrUnsupportedKey, err)
+		_, err = KeyFingerprint("not a key")
+		assert.Equal(t, ErrUnsupportedKey, err)
+
+		_, err = NewVerifier(ed25519.PublicKey(make([]byte, 16)), nil)
+		assert.Equal(t, ErrUnsupportedKey, err)
+	})
+}
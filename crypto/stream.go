@@ -0,0 +1,257 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// StreamChunkSize is the amount of plaintext encrypted per chunk by NewEncryptWriter.
+const StreamChunkSize = 64 * 1024
+
+// Streams use the sealMagic followed by version 2, a 32 byte random salt and a
+// sequence of AES-GCM encrypted chunks of StreamChunkSize bytes, except for the
+// last one which can be shorter:
+//
+//	magic (2) | version (1) | salt (32) | chunk 0 | chunk 1 | ... | final chunk
+//
+// Every stream is encrypted with its own key, derived from the secret key and
+// the salt with HKDF-SHA256, so nonces can't repeat across streams. The nonce
+// of each chunk is 7 zero bytes, the chunk's index as a 4 byte big endian
+// number and a byte that is 1 for the final chunk and 0 otherwise. The header
+// is the associated data of every chunk. Reordered, dropped or appended chunks
+// therefore fail to decrypt, and so does a stream cut off at a chunk boundary.
+const (
+	sealVersionStream          = 2
+	streamSaltSize             = 32
+	streamHeaderSize           = sealHeaderSize + streamSaltSize
+	streamCounterOffset        = 7
+	streamMaxChunks     uint64 = 1 << 32
+)
+
+var streamKeyInfo = []byte("eli stream chunk key")
+
+// ErrStreamTooLong is returned when a stream would need more chunks than nonces are available.
+var ErrStreamTooLong = errors.New("crypto: stream is too long")
+
+// streamCipher encrypts and decrypts the chunks of one stream.
+type streamCipher struct {
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint64
+}
+
+// newStreamCipher derives the key of the stream from secretKey and the salt of
+// header, it has the length of secretKey so the AES variant stays the same.
+func newStreamCipher(secretKey, header []byte) (*streamCipher, error) {
+	streamKey := make([]byte, len(secretKey))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secretKey, header[sealHeaderSize:], streamKeyInfo), streamKey); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	return &streamCipher{aead: aead, header: header, nonce: nonce}, nil
+}
+
+// next sets the nonce for the next chunk.
+func (c *streamCipher) next(final bool) error {
+	if c.counter >= streamMaxChunks {
+		return ErrStreamTooLong
+	}
+
+	binary.BigEndian.PutUint32(c.nonce[streamCounterOffset:], uint32(c.counter))
+	c.nonce[len(c.nonce)-1] = 0
+	if final {
+		c.nonce[len(c.nonce)-1] = 1
+	}
+	c.counter++
+	return nil
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	cipher *streamCipher
+	buf    []byte
+	out    []byte
+	err    error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// with AES-GCM in chunks of StreamChunkSize and writes the ciphertext to w.
+// Close must be called to write the final chunk, it doesn't close w. Memory
+// use doesn't depend on the size of the plaintext.
+// secretKey should be 16, 24, or 32 bytes.
+func NewEncryptWriter(w io.Writer, secretKey []byte) (io.WriteCloser, error) {
+	header := make([]byte, streamHeaderSize)
+	copy(header, sealMagic)
+	header[len(sealMagic)] = sealVersionStream
+	if _, err := io.ReadFull(rand.Reader, header[sealHeaderSize:]); err != nil {
+		return nil, err
+	}
+
+	c, err := newStreamCipher(secretKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		cipher: c,
+		buf:    make([]byte, 0, StreamChunkSize),
+		out:    make([]byte, 0, StreamChunkSize+c.aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only written once more data arrives, as it might be the final one
+		if len(e.buf) == StreamChunkSize {
+			if e.err = e.flush(false); e.err != nil {
+				return written, e.err
+			}
+		}
+
+		n := copy(e.buf[len(e.buf):StreamChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(final bool) error {
+	if err := e.cipher.next(final); err != nil {
+		return err
+	}
+
+	e.out = e.cipher.aead.Seal(e.out[:0], e.cipher.nonce, e.buf, e.cipher.header)
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Close writes the final chunk.
+func (e *encryptWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if e.err = e.flush(true); e.err != nil {
+		return e.err
+	}
+	e.err = errors.New("crypto: write to closed stream")
+	return nil
+}
+
+type decryptReader struct {
+	r         io.Reader
+	cipher    *streamCipher
+	buf       []byte
+	n         int
+	out       []byte
+	plaintext []byte
+	final     bool
+	err       error
+}
+
+// NewDecryptReader returns a reader of the plaintext of a stream written by
+// NewEncryptWriter to r. Read returns ErrTampered if a chunk was modified,
+// reordered or removed, and io.EOF only after the final chunk was authenticated.
+func NewDecryptReader(r io.Reader, secretKey []byte) (io.Reader, error) {
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	if !hasSealHeader(header) || header[len(sealMagic)] != sealVersionStream {
+		return nil, ErrUnsupportedVersion
+	}
+
+	c, err := newStreamCipher(secretKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// One byte more than a chunk is read to tell whether the chunk is the last one
+	return &decryptReader{
+		r:      r,
+		cipher: c,
+		buf:    make([]byte, StreamChunkSize+c.aead.Overhead()+1),
+		out:    make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.final {
+			return 0, io.EOF
+		}
+		d.err = d.readChunk()
+	}
+
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	n, err := io.ReadFull(d.r, d.buf[d.n:])
+	d.n += n
+
+	chunk := d.buf[:d.n]
+	switch err {
+	case nil:
+		chunk = d.buf[:len(d.buf)-1]
+	case io.EOF, io.ErrUnexpectedEOF:
+		d.final = true
+	default:
+		return err
+	}
+
+	if len(chunk) < d.cipher.aead.Overhead() {
+		return ErrTruncated
+	}
+
+	if err := d.cipher.next(d.final); err != nil {
+		return err
+	}
+
+	plaintext, err := d.cipher.aead.Open(d.out[:0], d.cipher.nonce, chunk, d.cipher.header)
+	if err != nil {
+		return ErrTampered
+	}
+	d.plaintext = plaintext
+
+	if !d.final {
+		// Keep the byte read ahead for the next chunk
+		d.buf[0] = d.buf[len(d.buf)-1]
+		d.n = 1
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptStream(t *testing.T, plaintext []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	require.NoError(t, err)
+
+	// Odd write sizes so that writes straddle chunk boundaries
+	for len(plaintext) > 0 {
+		n := 1000
+		if n > len(plaintext) {
+			n = len(plaintext)
+		}
+		_, err := w.Write(plaintext[:n])
+		require.NoError(t, err)
+		plaintext = plaintext[n:]
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decryptStream(ciphertext []byte) ([]byte, error) {
+	r, err := NewDecryptReader(iotest.HalfReader(bytes.NewReader(ciphertext)), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestStream(t *testing.T) {
+	sizes := []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 17}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		ciphertext := encryptStream(t, plaintext)
+		chunks := (size + StreamChunkSize - 1) / StreamChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		assert.Len(t, ciphertext, streamHeaderSize+size+chunks*16, "size %d", size)
+
+		decrypted, err := decryptStream(ciphertext)
+		require.NoError(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(plaintext, decrypted), "size %d", size)
+	}
+
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), StreamChunkSize/4)
+	ciphertext := encryptStream(t, plaintext)
+	chunk := StreamChunkSize + 16
+
+	t.Run("Truncated", func(t *testing.T) {
+		// Cut off at chunk boundaries, within chunks and within the header
+		for _, n := range []int{0, 5, streamHeaderSize, streamHeaderSize + 10, streamHeaderSize + chunk, streamHeaderSize + 2*chunk, len(ciphertext) - 1} {
+			_, err := decryptStream(ciphertext[:n])
+			assert.Error(t, err, "length %d", n)
+		}
+	})
+
+	t.Run("Appended", func(t *testing.T) {
+		_, err := decryptStream(append(append([]byte{}, ciphertext...), 0))
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		reordered := append([]byte{}, ciphertext[:streamHeaderSize]...)
+		reordered = append(reordered, ciphertext[streamHeaderSize+chunk:streamHeaderSize+2*chunk]...)
+		reordered = append(reordered, ciphertext[streamHeaderSize:streamHeaderSize+chunk]...)
+		reordered = append(reordered, ciphertext[streamHeaderSize+2*chunk:]...)
+		_, err := decryptStream(reordered)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		dropped := append(append([]byte{}, ciphertext[:streamHeaderSize+chunk]...), ciphertext[streamHeaderSize+2*chunk:]...)
+		_, err := decryptStream(dropped)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		for _, i := range []int{3, streamHeaderSize, streamHeaderSize + chunk + 100, len(ciphertext) - 1} {
+			tampered := append([]byte{}, ciphertext...)
+			tampered[i] ^= 1
+			_, err := decryptStream(tampered)
+			assert.Equal(t, ErrTampered, err, "byte %d", i)
+		}
+	})
+
+	t.Run("PartialRead", func(t *testing.T) {
+		// Plaintext of authenticated chunks is returned before a later chunk fails
+		r, err := NewDecryptReader(bytes.NewReader(ciphertext[:len(ciphertext)-1]), key)
+		require.NoError(t, err)
+
+		decrypted, err := ioutil.ReadAll(r)
+		assert.Equal(t, ErrTampered, err)
+		assert.Equal(t, plaintext[:len(decrypted)], decrypted)
+		assert.Equal(t, 0, len(decrypted)%StreamChunkSize)
+	})
+
+	t.Run("PerStreamKey", func(t *testing.T) {
+		other := encryptStream(t, plaintext)
+		assert.NotEqual(t, ciphertext[:streamHeaderSize], other[:streamHeaderSize], "salts must be random")
+		assert.NotEqual(t, ciphertext[streamHeaderSize:streamHeaderSize+chunk], other[streamHeaderSize:streamHeaderSize+chunk])
+
+		// Chunks only decrypt under the salt they were encrypted with
+		spliced := append(append([]byte{}, other[:streamHeaderSize]...), ciphertext[streamHeaderSize:]...)
+		_, err := decryptStream(spliced)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		r, err := NewDecryptReader(bytes.NewReader(ciphertext), []byte("0123456789abcdef0123456789abcdef"))
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		assert.Equal(t, ErrTampered, err)
+	})
+
+	t.Run("NotAStream", func(t *testing.T) {
+		sealed, err := Seal([]byte("secret"), key, nil)
+		require.NoError(t, err)
+		_, err = NewDecryptReader(bytes.NewReader(append(sealed, make([]byte, 10)...)), key)
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	})
+
+	t.Run("WriteAfterClose", func(t *testing.T) {
+		w, err := NewEncryptWriter(ioutil.Discard, key)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		_, err = w.Write([]byte("more"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Pipe", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		go func() {
+			w, err := NewEncryptWriter(pw, key)
+			if err == nil {
+				_, err = io.Copy(w, bytes.NewReader(plaintext))
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+
+		r, err := NewDecryptReader(pr, key)
+		require.NoError(t, err)
+		decrypted, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(plaintext, decrypted))
+	})
+}
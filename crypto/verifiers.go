@@ -0,0 +1,210 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PasswordVerifier verifies password hashes of one format, usually one written
+// by another system that users are migrated from.
+type PasswordVerifier interface {
+	// Recognizes reports whether hashed is in the format of this verifier
+	Recognizes(hashed []byte) bool
+	// Verify reports whether password matches hashed
+	Verify(hashed, password []byte) (bool, error)
+}
+
+// VerifierRegistry verifies password hashes of every format it has a verifier
+// for. It is safe for concurrent use.
+type VerifierRegistry struct {
+	mu        sync.RWMutex
+	verifiers []PasswordVerifier
+}
+
+// NewVerifierRegistry returns a registry with the given verifiers.
+func NewVerifierRegistry(verifiers ...PasswordVerifier) *VerifierRegistry {
+	return &VerifierRegistry{verifiers: verifiers}
+}
+
+// DefaultVerifierRegistry is used by ComparePasswordHash and VerifyPassword. It
+// knows the formats of the hashers in this package, Django's PBKDF2 hashes, the
+// PBKDF2 hashes of passlib and salted SHA hashes as stored by LDAP servers.
+var DefaultVerifierRegistry = NewVerifierRegistry(
+	&Argon2idHasher{},
+	&ScryptHasher{},
+	&BcryptHasher{},
+	&PBKDF2Verifier{},
+	&SaltedSHAVerifier{},
+)
+
+// Register adds verifier to the registry. Verifiers are tried in the order
+// they were added and the first one recognizing a hash is used.
+func (r *VerifierRegistry) Register(verifier PasswordVerifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.verifiers = append(r.verifiers, verifier)
+}
+
+// Verify reports whether password matches hashed.
+func (r *VerifierRegistry) Verify(hashed, password []byte) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, verifier := range r.verifiers {
+		if verifier.Recognizes(hashed) {
+			return verifier.Verify(hashed, password)
+		}
+	}
+	return false, ErrUnsupportedHash
+}
+
+// VerifyPassword reports whether password matches hashedPassword, which can be in
+// any format of DefaultVerifierRegistry, and whether hashedPassword should be
+// replaced with a hash of DefaultPasswordHasher now that the password is known.
+func VerifyPassword(hashedPassword, password []byte) (ok bool, needsRehash bool) {
+	ok, err := DefaultVerifierRegistry.Verify(hashedPassword, password)
+	if err != nil || !ok {
+		return false, false
+	}
+	return true, NeedsRehash(hashedPassword)
+}
+
+func (h *Argon2idHasher) Recognizes(hashed []byte) bool {
+	return bytes.HasPrefix(hashed, []byte("$argon2id$"))
+}
+
+func (h *ScryptHasher) Recognizes(hashed []byte) bool {
+	return bytes.HasPrefix(hashed, []byte("$scrypt$"))
+}
+
+func (h *BcryptHasher) Recognizes(hashed []byte) bool {
+	return isBcryptHash(hashed)
+}
+
+// PBKDF2Verifier verifies PBKDF2 hashes in the formats of Django
+// (pbkdf2_sha256$iterations$salt$hash and pbkdf2_sha1$...) and passlib
+// ($pbkdf2$iterations$salt$hash, $pbkdf2-sha256$... and $pbkdf2-sha512$...).
+type PBKDF2Verifier struct{}
+
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"pbkdf2_sha1":    sha1.New,
+	"pbkdf2_sha256":  sha256.New,
+	"$pbkdf2":        sha1.New,
+	"$pbkdf2-sha256": sha256.New,
+	"$pbkdf2-sha512": sha512.New,
+}
+
+// pbkdf2ID returns the identifier a PBKDF2 hash starts with, including the
+// leading $ of passlib hashes.
+func pbkdf2ID(hashed []byte) string {
+	s, prefix := string(hashed), ""
+	if strings.HasPrefix(s, "$") {
+		s, prefix = s[1:], "$"
+	}
+
+	end := strings.IndexByte(s, '$')
+	if end < 0 {
+		return ""
+	}
+	return prefix + s[:end]
+}
+
+func (v *PBKDF2Verifier) Recognizes(hashed []byte) bool {
+	_, ok := pbkdf2Digests[pbkdf2ID(hashed)]
+	return ok
+}
+
+func (v *PBKDF2Verifier) Verify(hashed, password []byte) (bool, error) {
+	id := pbkdf2ID(hashed)
+	digest, ok := pbkdf2Digests[id]
+	if !ok {
+		return false, ErrUnsupportedHash
+	}
+
+	passlib := strings.HasPrefix(id, "$")
+	fields := strings.Split(strings.TrimPrefix(string(hashed), "$"), "$")
+	if len(fields) != 4 {
+		return false, ErrUnsupportedHash
+	}
+
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations <= 0 {
+		return false, ErrUnsupportedHash
+	}
+
+	// Django stores the salt as is and the hash as padded base64, passlib uses
+	// its adapted base64 with . instead of + for both
+	salt, key := []byte(fields[2]), []byte(nil)
+	if passlib {
+		if salt, err = passlibDecode(fields[2]); err != nil {
+			return false, ErrUnsupportedHash
+		}
+		key, err = passlibDecode(fields[3])
+	} else {
+		key, err = base64.StdEncoding.DecodeString(fields[3])
+	}
+	if err != nil || len(key) == 0 {
+		return false, ErrUnsupportedHash
+	}
+
+	derived := pbkdf2.Key(password, salt, iterations, len(key), digest)
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+func passlibDecode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.Replace(s, ".", "+", -1))
+}
+
+// SaltedSHAVerifier verifies the salted SHA hashes of LDAP servers:
+// {SSHA}, {SSHA256} or {SSHA512} followed by the base64 encoding of the digest
+// of the password and salt, followed by the salt.
+type SaltedSHAVerifier struct{}
+
+var saltedSHADigests = map[string]func() hash.Hash{
+	"{SSHA}":    sha1.New,
+	"{SSHA256}": sha256.New,
+	"{SSHA512}": sha512.New,
+}
+
+func (v *SaltedSHAVerifier) scheme(hashed []byte) (string, func() hash.Hash) {
+	end := bytes.IndexByte(hashed, '}')
+	if end < 0 {
+		return "", nil
+	}
+
+	scheme := strings.ToUpper(string(hashed[:end+1]))
+	return scheme, saltedSHADigests[scheme]
+}
+
+func (v *SaltedSHAVerifier) Recognizes(hashed []byte) bool {
+	_, digest := v.scheme(hashed)
+	return digest != nil
+}
+
+func (v *SaltedSHAVerifier) Verify(hashed, password []byte) (bool, error) {
+	scheme, digest := v.scheme(hashed)
+	if digest == nil {
+		return false, ErrUnsupportedHash
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(string(hashed[len(scheme):]))
+	h := digest()
+	if err != nil || len(raw) <= h.Size() {
+		return false, ErrUnsupportedHash
+	}
+
+	sum, salt := raw[:h.Size()], raw[h.Size():]
+	h.Write(password)
+	h.Write(salt)
+	return subtle.ConstantTimeCompare(h.Sum(nil), sum) == 1, nil
+}
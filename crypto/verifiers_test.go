@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// djangoHash writes a hash the way Django's PBKDF2 hashers do.
+func djangoHash(id string, digest func() hash.Hash, password string) string {
+	key := pbkdf2.Key([]byte(password), []byte("seasalt"), 1000, digest().Size(), digest)
+	return fmt.Sprintf("%s$1000$seasalt$%s", id, base64.StdEncoding.EncodeToString(key))
+}
+
+// passlibHash writes a hash the way passlib's PBKDF2 hashers do.
+func passlibHash(id string, digest func() hash.Hash, password string) string {
+	salt := []byte{0xfb, 0xef, 0xbe, 0x01, 0x02, 0x03}
+	key := pbkdf2.Key([]byte(password), salt, 1000, digest().Size(), digest)
+	encode := func(b []byte) string {
+		return strings.Replace(base64.RawStdEncoding.EncodeToString(b), "+", ".", -1)
+	}
+	return fmt.Sprintf("%s$1000$%s$%s", id, encode(salt), encode(key))
+}
+
+// sshaHash writes a salted SHA hash the way LDAP servers do.
+func sshaHash(scheme string, digest func() hash.Hash, password string) string {
+	salt := []byte("salt1234")
+	h := digest()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return scheme + base64.StdEncoding.EncodeToString(append(h.Sum(nil), salt...))
+}
+
+func TestVerifierRegistry(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	hashes := map[string]string{
+		"DjangoPBKDF2SHA256":  djangoHash("pbkdf2_sha256", sha256.New, "hunter2"),
+		"DjangoPBKDF2SHA1":    djangoHash("pbkdf2_sha1", sha1.New, "hunter2"),
+		"PasslibPBKDF2":       passlibHash("$pbkdf2", sha1.New, "hunter2"),
+		"PasslibPBKDF2SHA256": passlibHash("$pbkdf2-sha256", sha256.New, "hunter2"),
+		"PasslibPBKDF2SHA512": passlibHash("$pbkdf2-sha512", sha512.New, "hunter2"),
+		"SSHA":                sshaHash("{SSHA}", sha1.New, "hunter2"),
+		"SSHA256":             sshaHash("{SSHA256}", sha256.New, "hunter2"),
+		"SSHA512":             sshaHash("{ssha512}", sha512.New, "hunter2"),
+		"Bcrypt2y":            "$2y$" + string(bcryptHash[4:]),
+		"Bcrypt2b":            "$2b$" + string(bcryptHash[4:]),
+	}
+
+	for name, hashed := range hashes {
+		hashed := hashed
+		t.Run(name, func(t *testing.T) {
+			ok, needsRehash := VerifyPassword([]byte(hashed), []byte("hunter2"))
+			assert.True(t, ok)
+			assert.True(t, needsRehash)
+
+			ok, needsRehash = VerifyPassword([]byte(hashed), []byte("hunter3"))
+			assert.False(t, ok)
+			assert.False(t, needsRehash)
+
+			assert.True(t, ComparePasswordHash([]byte(hashed), []byte("hunter2")))
+		})
+	}
+
+	t.Run("Current", func(t *testing.T) {
+		hashed, err := GeneratePasswordHash([]byte("hunter2"))
+		require.NoError(t, err)
+
+		ok, needsRehash := VerifyPassword(hashed, []byte("hunter2"))
+		assert.True(t, ok)
+		assert.False(t, needsRehash)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, hashed := range []string{
+			"pbkdf2_sha256$abc$salt$aGFzaA==",
+			"pbkdf2_sha256$1000$salt",
+			"pbkdf2_sha256$1000$salt$!!",
+			"pbkdf2_md5$1000$salt$aGFzaA==",
+			"{SSHA}c2hvcnQ=",
+			"{SSHA}!!",
+			"{MD5}aGFzaA==",
+		} {
+			_, err := DefaultVerifierRegistry.Verify([]byte(hashed), []byte("hunter2"))
+			assert.Error(t, err, hashed)
+		}
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		registry := NewVerifierRegistry(&BcryptHasher{})
+		_, err := registry.Verify([]byte("plain:hunter2"), []byte("hunter2"))
+		assert.Equal(t, ErrUnsupportedHash, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				registry.Verify(bcryptHash, []byte("hunter2"))
+			}()
+		}
+		registry.Register(plainVerifier{})
+		wg.Wait()
+
+		ok, err := registry.Verify([]byte("plain:hunter2"), []byte("hunter2"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+// plainVerifier is a PasswordVerifier for a made-up format.
+type plainVerifier struct{}
+
+func (plainVerifier) Recognizes(hashed []byte) bool {
+	return bytes.HasPrefix(hashed, []byte("plain:"))
+}
+
+func (plainVerifier) Verify(hashed, password []byte) (bool, error) {
+	return bytes.Equal(hashed[len("plain:"):], password), nil
+}